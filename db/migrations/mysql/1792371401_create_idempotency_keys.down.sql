@@ -0,0 +1,5 @@
+-- 1792371401_create_idempotency_keys.down.sql
+-- Created at 2026-10-19T00:56:41Z
+-- By euiko
+
+DROP TABLE IF EXISTS idempotency_keys;
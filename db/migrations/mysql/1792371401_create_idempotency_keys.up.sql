@@ -0,0 +1,15 @@
+-- 1792371401_create_idempotency_keys.up.sql
+-- Created at 2026-10-19T00:56:41Z
+-- By euiko
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    lock_token VARCHAR(32) NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0,
+    header BLOB,
+    body LONGBLOB,
+    created_at DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    expires_at DATETIME(3) NOT NULL,
+    INDEX idempotency_keys_expires_at_idx (expires_at)
+);
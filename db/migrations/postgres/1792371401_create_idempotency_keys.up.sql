@@ -0,0 +1,16 @@
+-- 1792371401_create_idempotency_keys.up.sql
+-- Created at 2026-10-19T00:56:41Z
+-- By euiko
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    lock_token VARCHAR(32) NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0,
+    header BYTEA,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
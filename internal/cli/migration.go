@@ -1,45 +1,119 @@
 package cli
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/euiko/go-fullstack-boilerplate/internal/core/webapp"
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/spf13/cobra"
 )
 
 var (
-	migrationPath string
-	regex         = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+	migrationPath   string
+	migrationDB     string
+	migrationModule string
+	regex           = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
 )
 
-func Migration(s *webapp.Settings) webapp.Module {
-	return webapp.NewModule(webapp.WithCLI(func(cmd *cobra.Command) {
-		cmd.AddCommand(migrationCmd(s))
-	}))
+type (
+	// MigrationStatus is the state of a migration, one of applied, pending or dirty
+	MigrationStatus struct {
+		Module  string `json:"module"`
+		Version uint   `json:"version"`
+		Name    string `json:"name"`
+		Status  string `json:"status"`
+	}
+
+	// MigrationVersion is the current version of the database, nil when not migrated
+	MigrationVersion struct {
+		Version *uint `json:"version"`
+		Dirty   bool  `json:"dirty"`
+	}
+)
+
+func Migration(app *webapp.App) func(s *webapp.Settings) webapp.Module {
+	return func(s *webapp.Settings) webapp.Module {
+		return webapp.NewModule(webapp.WithName("migration"), webapp.WithCLI(func(cmd *cobra.Command) {
+			cmd.AddCommand(webapp.WithCommandScopes(migrationCmd(app, s), webapp.ScopeMigration))
+		}))
+	}
 }
 
-func migrationCmd(s *webapp.Settings) *cobra.Command {
+func migrationCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "migration",
 		Short: "Migrate the database",
 	}
-	cmd.PersistentFlags().StringVarP(&migrationPath, "path", "p", "./db/migrations", "Path to migration files")
-	cmd.AddCommand(migrationUpCmd(s))
-	cmd.AddCommand(migrationDownCmd(s))
+	cmd.PersistentFlags().StringVarP(&migrationPath, "path", "p", "", "Path to migration files, overrides the embedded migrations (default \""+webapp.DefaultMigrationPath+"\")")
+	cmd.PersistentFlags().StringVarP(&migrationDB, "db", "d", "default", "Name of the database to migrate")
+	cmd.PersistentFlags().StringVarP(&migrationModule, "module", "m", "", "Migration set of the module, every set when empty or "+webapp.AppMigrationSet+" for the app migrations")
+	cmd.AddCommand(migrationUpCmd(app, s))
+	cmd.AddCommand(migrationDownCmd(app, s))
 	cmd.AddCommand(migrationNewCmd())
+	cmd.AddCommand(migrationStatusCmd(app, s))
+	cmd.AddCommand(migrationVersionCmd(app, s))
+	cmd.AddCommand(migrationGotoCmd(app, s))
+	cmd.AddCommand(migrationForceCmd(app, s))
+	cmd.AddCommand(migrationDropCmd(app, s))
 	return cmd
 }
 
-func migrationUpCmd(s *webapp.Settings) *cobra.Command {
+// newMigrate creates the migration of the set on the selected database
+func newMigrate(s *webapp.Settings, set webapp.MigrationSet) (*migrate.Migrate, source.Driver, error) {
+	settings, ok := s.DatabaseConnections()[migrationDB]
+	if !ok {
+		return nil, nil, fmt.Errorf("database %s is not configured", migrationDB)
+	}
+	return webapp.NewMigrate(settings, set)
+}
+
+// migrationSets returns the selected migration set, otherwise every set in
+// dependency order. The path defaults to the one of the migration settings
+func migrationSets(app *webapp.App, s *webapp.Settings) ([]webapp.MigrationSet, error) {
+	path := migrationPath
+	if path == "" {
+		path = s.Migration.Path
+	}
+
+	sets, err := app.MigrationSets(path)
+	if err != nil || migrationModule == "" {
+		return sets, err
+	}
+
+	for _, set := range sets {
+		if set.Name == migrationModule {
+			return []webapp.MigrationSet{set}, nil
+		}
+	}
+	return nil, fmt.Errorf("module %s has no migrations", migrationModule)
+}
+
+// migrationSet returns the selected migration set, default to the app migrations
+func migrationSet(app *webapp.App, s *webapp.Settings) (webapp.MigrationSet, error) {
+	sets, err := migrationSets(app, s)
+	if err != nil {
+		return webapp.MigrationSet{}, err
+	}
+
+	// the app migrations are the last one
+	return sets[len(sets)-1], nil
+}
+
+func migrationUpCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
 	var (
 		steps int
 		force int
@@ -48,78 +122,441 @@ func migrationUpCmd(s *webapp.Settings) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "up",
 		Short: "Migrate the database up",
+		Long:  "Migrate the database up, the migration sets of the modules are applied in dependency order before the app migrations",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			source := fmt.Sprintf("file://%s", migrationPath)
-			m, err := migrate.New(source, s.DB.Uri)
+			sets, err := migrationSets(app, s)
 			if err != nil {
 				return err
 			}
 
-			// force migration
-			if force > 0 {
-				if err := m.Force(force); err != nil {
-					return err
-				}
-			}
-
-			if steps > 0 {
-				err = m.Steps(steps)
-			} else {
-				err = m.Up()
+			if (steps > 0 || force > 0) && len(sets) > 1 {
+				return errors.New("select the migration set using --module to apply steps or force a version")
 			}
 
-			if err != migrate.ErrNoChange {
-				return err
+			for _, set := range sets {
+				if err := migrateUp(s, set, steps, force); err != nil {
+					return fmt.Errorf("migration set %s: %w", set.Name, err)
+				}
 			}
-
 			return nil
 		},
 	}
 	cmd.Flags().IntVarP(&steps, "steps", "s", 0, "Number of migrations to apply")
 	cmd.Flags().IntVarP(&force, "force", "f", 0, "Force specific version to apply")
+	cmd.Flags().MarkDeprecated("force", "use the migration force command instead")
 	return cmd
 }
 
-func migrationDownCmd(s *webapp.Settings) *cobra.Command {
+func migrateUp(s *webapp.Settings, set webapp.MigrationSet, steps int, force int) error {
+	m, _, err := newMigrate(s, set)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// force migration
+	if force > 0 {
+		if err := m.Force(force); err != nil {
+			return err
+		}
+	}
+
+	if steps > 0 {
+		err = m.Steps(steps)
+	} else {
+		err = m.Up()
+	}
+
+	if err != migrate.ErrNoChange {
+		return err
+	}
+
+	return nil
+}
+
+func migrationDownCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
 	var steps int
 
 	cmd := &cobra.Command{
 		Use:   "down",
 		Short: "Migrate the database down",
+		Long:  "Migrate the database down, the app migrations are reverted before the migration sets of the modules",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			source := fmt.Sprintf("file://%s", migrationPath)
-			m, err := migrate.New(source, s.DB.Uri)
+			sets, err := migrationSets(app, s)
 			if err != nil {
 				return err
 			}
 
-			if steps > 0 {
-				// negate the steps to revert migrations
-				return m.Steps(-1 * steps)
-			} else {
-				return m.Down()
+			if steps > 0 && len(sets) > 1 {
+				return errors.New("select the migration set using --module to revert steps")
+			}
+
+			// revert the dependents first
+			slices.Reverse(sets)
+			for _, set := range sets {
+				if err := migrateDown(s, set, steps); err != nil {
+					return fmt.Errorf("migration set %s: %w", set.Name, err)
+				}
 			}
+			return nil
 		},
 	}
 	cmd.Flags().IntVarP(&steps, "steps", "s", 0, "Number of migrations to revert")
 	return cmd
 }
 
+func migrateDown(s *webapp.Settings, set webapp.MigrationSet, steps int) error {
+	m, _, err := newMigrate(s, set)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if steps > 0 {
+		// negate the steps to revert migrations
+		err = m.Steps(-1 * steps)
+	} else {
+		err = m.Down()
+	}
+
+	if err != migrate.ErrNoChange {
+		return err
+	}
+
+	return nil
+}
+
+func migrationStatusCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the migrations with their status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sets, err := migrationSets(app, s)
+			if err != nil {
+				return err
+			}
+
+			var migrations []MigrationStatus
+			for _, set := range sets {
+				statuses, err := migrationStatuses(s, set)
+				if err != nil {
+					return fmt.Errorf("migration set %s: %w", set.Name, err)
+				}
+				migrations = append(migrations, statuses...)
+			}
+
+			switch output {
+			case "json":
+				return writeJSON(cmd, migrations)
+			case "table":
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "MODULE\tVERSION\tNAME\tSTATUS")
+				for _, migration := range migrations {
+					fmt.Fprintf(w, "%s\t%d\t%s\t%s\n",
+						migration.Module,
+						migration.Version,
+						orDash(migration.Name),
+						migration.Status,
+					)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+
+				for _, migration := range migrations {
+					if migration.Status == "dirty" {
+						fmt.Fprintf(cmd.OutOrStdout(),
+							"\nThe %s migrations are dirty at version %d, fix it manually then run migration force --module %s\n",
+							migration.Module, migration.Version, migration.Module)
+					}
+				}
+				return nil
+			default:
+				return fmt.Errorf("unknown output format %s, use table or json", output)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format, table or json")
+	return cmd
+}
+
+// migrationStatuses lists every migration of the set, those up to the current
+// version are applied
+func migrationStatuses(s *webapp.Settings, set webapp.MigrationSet) ([]MigrationStatus, error) {
+	m, src, err := newMigrate(s, set)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
+	}
+	migrated := err == nil
+
+	var migrations []MigrationStatus
+	version, err := src.First()
+	for err == nil {
+		name, nameErr := migrationName(src, version)
+		if nameErr != nil {
+			return nil, nameErr
+		}
+
+		status := "pending"
+		switch {
+		case migrated && version == current && dirty:
+			status = "dirty"
+		case migrated && version <= current:
+			status = "applied"
+		}
+		migrations = append(migrations, MigrationStatus{
+			Module:  set.Name,
+			Version: version,
+			Name:    name,
+			Status:  status,
+		})
+
+		version, err = src.Next(version)
+	}
+
+	// the source is exhausted
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+// migrationName returns the name of the up migration, e.g. create_users
+func migrationName(src source.Driver, version uint) (string, error) {
+	r, name, err := src.ReadUp(version)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	r.Close()
+	return name, nil
+}
+
+func migrationVersionCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the current migration version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set, err := migrationSet(app, s)
+			if err != nil {
+				return err
+			}
+
+			m, _, err := newMigrate(s, set)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			var version MigrationVersion
+			current, dirty, err := m.Version()
+			switch {
+			case errors.Is(err, migrate.ErrNilVersion):
+			case err != nil:
+				return err
+			default:
+				version.Version = &current
+				version.Dirty = dirty
+			}
+
+			switch output {
+			case "json":
+				return writeJSON(cmd, version)
+			case "table":
+				switch {
+				case version.Version == nil:
+					fmt.Fprintln(cmd.OutOrStdout(), "none")
+				case version.Dirty:
+					fmt.Fprintf(cmd.OutOrStdout(), "%d (dirty)\n", *version.Version)
+				default:
+					fmt.Fprintln(cmd.OutOrStdout(), *version.Version)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unknown output format %s, use table or json", output)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format, table or json")
+	return cmd
+}
+
+func migrationGotoCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "goto VERSION",
+		Short: "Migrate the database up or down to the version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version %s: %w", args[0], err)
+			}
+
+			set, err := migrationSet(app, s)
+			if err != nil {
+				return err
+			}
+
+			m, _, err := newMigrate(s, set)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			if err := m.Migrate(uint(version)); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func migrationForceCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "force VERSION",
+		Short: "Set the migration version without migrating, e.g. to clear the dirty state",
+		Long: "Set the migration version without migrating, e.g. to clear the dirty state after fixing\n" +
+			"a failed migration manually. Use force -- -1 to mark the database as not migrated,\n" +
+			"the -- keeps -1 from being parsed as a flag",
+		Example: "  migration force 20240101120000\n" +
+			"  migration force --yes -- -1",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil || version < database.NilVersion {
+				return fmt.Errorf("invalid version %s", args[0])
+			}
+
+			set, err := migrationSet(app, s)
+			if err != nil {
+				return err
+			}
+
+			prompt := fmt.Sprintf("Force the %s migrations of the %s database to version %d without migrating?",
+				set.Name, migrationDB, version)
+			if !yes && !confirm(cmd, prompt) {
+				return errors.New("aborted")
+			}
+
+			m, _, err := newMigrate(s, set)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			return m.Force(version)
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation")
+	return cmd
+}
+
+func migrationDropCmd(app *webapp.App, s *webapp.Settings) *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "drop",
+		Short: "Drop everything in the database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the tables of every set are dropped, not only those of the module
+			if migrationModule != "" {
+				return errors.New("drop can't be limited to a module, it drops every table of the database")
+			}
+
+			prompt := fmt.Sprintf("Drop every table of the %s database? This can't be undone", migrationDB)
+			if !yes && !confirm(cmd, prompt) {
+				return errors.New("aborted")
+			}
+
+			set, err := migrationSet(app, s)
+			if err != nil {
+				return err
+			}
+
+			m, _, err := newMigrate(s, set)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+
+			return m.Drop()
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation")
+	return cmd
+}
+
+// confirm asks the user to confirm the prompt through the command input
+func confirm(cmd *cobra.Command, prompt string) bool {
+	fmt.Fprintf(cmd.OutOrStdout(), "%s [y/N] ", prompt)
+
+	answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+func writeJSON(cmd *cobra.Command, v any) error {
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
 func migrationNewCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "new [NAME]",
 		Short: "Generate a new migration file",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// the modules ship their migrations in their own file system
+			if migrationModule != "" && migrationModule != webapp.AppMigrationSet {
+				return errors.New("only the app migrations can be created")
+			}
+
 			name := args[0]
-			return createMigrationFile(migrationPath, name)
+			return createMigrationFile(name, migrationDirs(webapp.MigrationPath(migrationPath))...)
 		},
 	}
 
 	return cmd
 }
 
-func createMigrationFile(dir string, name string) error {
+// migrationDirs returns the per driver migration directories, or the base
+// directory when there is none
+func migrationDirs(base string) []string {
+	var dirs []string
+	for _, driver := range []string{webapp.DriverPostgres, webapp.DriverMySQL, webapp.DriverSQLite} {
+		if dir := webapp.MigrationDir(base, driver); dir != base {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if len(dirs) == 0 {
+		return []string{base}
+	}
+	return dirs
+}
+
+// createMigrationFile creates the up and down files with the same version in every directory
+func createMigrationFile(name string, dirs ...string) error {
 	// replace all space and dash with underscore
 	name = strings.ReplaceAll(name, " ", "_")
 	name = strings.ReplaceAll(name, "-", "_")
@@ -134,11 +571,6 @@ func createMigrationFile(dir string, name string) error {
 		return errors.New("you need to specify the migration name (alphanumeric with lowercase)")
 	}
 
-	// ensure directory exists
-	if err := os.MkdirAll(dir, os.ModeDir); err != nil {
-		return err
-	}
-
 	// add version
 	now := time.Now()
 	version := now.Unix()
@@ -149,18 +581,25 @@ func createMigrationFile(dir string, name string) error {
 		user = "-"
 	}
 
-	for _, direction := range []string{"up", "down"} {
-		filename := fmt.Sprintf("%s.%s.sql", title, direction)
-		file, err := os.Create(filepath.Join(dir, filename))
-		if err != nil {
+	for _, dir := range dirs {
+		// ensure directory exists
+		if err := os.MkdirAll(dir, os.ModeDir); err != nil {
 			return err
 		}
-		defer file.Close()
 
-		fmt.Fprintf(file, "-- %s\n", filename)
-		fmt.Fprintf(file, "-- Created at %s\n", now.Format(time.RFC3339))
-		fmt.Fprintf(file, "-- By %s\n", user)
-		fmt.Fprintf(file, "\n")
+		for _, direction := range []string{"up", "down"} {
+			filename := fmt.Sprintf("%s.%s.sql", title, direction)
+			file, err := os.Create(filepath.Join(dir, filename))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			fmt.Fprintf(file, "-- %s\n", filename)
+			fmt.Fprintf(file, "-- Created at %s\n", now.Format(time.RFC3339))
+			fmt.Fprintf(file, "-- By %s\n", user)
+			fmt.Fprintf(file, "\n")
+		}
 	}
 
 	return nil
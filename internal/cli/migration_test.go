@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/webapp"
+)
+
+func TestMigrationCmdArgs(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1_create_items.up.sql":    "CREATE TABLE items (id INTEGER PRIMARY KEY);",
+		"1_create_items.down.sql":  "DROP TABLE items;",
+		"2_create_orders.up.sql":   "CREATE TABLE orders (id INTEGER PRIMARY KEY);",
+		"2_create_orders.down.sql": "DROP TABLE orders;",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := webapp.NewApp("test", "t")
+	settings := webapp.Settings{
+		DB:        webapp.DatabaseSettings{Uri: "sqlite://" + filepath.Join(dir, "app.db")},
+		Migration: webapp.MigrationSettings{Path: dir},
+	}
+
+	run := func(input string, args ...string) (string, error) {
+		cmd := migrationCmd(app, &settings)
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetIn(strings.NewReader(input))
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	// the steps share the database, the version is checked after each one
+	tests := []struct {
+		args    string
+		input   string
+		fails   bool
+		version string
+	}{
+		{"version", "", false, "none"},
+		{"up --steps 1", "", false, "1"},
+		{"up", "", false, "2"},
+		{"up --module unknown", "", true, "2"},
+		{"status --output json", "", false, "2"},
+		{"status --output yaml", "", true, "2"},
+		{"version -o yaml", "", true, "2"},
+		{"down --steps 1", "", false, "1"},
+		{"goto 2", "", false, "2"},
+		{"goto", "", true, "2"},
+		{"goto latest", "", true, "2"},
+		{"force 1", "n\n", true, "2"},
+		{"force 1", "y\n", false, "1"},
+		{"force --yes 2", "", false, "2"},
+		{"force --yes -1", "", true, "2"},
+		{"force --yes -- -2", "", true, "2"},
+		{"force --yes -- -1", "", false, "none"},
+		{"force --yes 1 2", "", true, "none"},
+	}
+	for _, test := range tests {
+		_, err := run(test.input, strings.Fields(test.args)...)
+		if (err != nil) != test.fails {
+			t.Fatalf("%s: expected failure %v, got %v", test.args, test.fails, err)
+		}
+
+		out, err := run("", "version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := strings.TrimSpace(out); version != test.version {
+			t.Fatalf("%s: expected version %s, got %s", test.args, test.version, version)
+		}
+	}
+}
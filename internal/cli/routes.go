@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/webapp"
+	"github.com/spf13/cobra"
+)
+
+func Routes(app *webapp.App) func(settings *webapp.Settings) webapp.Module {
+	return func(settings *webapp.Settings) webapp.Module {
+		return webapp.NewModule(webapp.WithName("routes"), webapp.WithCLI(func(cmd *cobra.Command) {
+			// the routes are built by the initialized server modules
+			cmd.AddCommand(webapp.WithCommandScopes(routesCmd(app), webapp.ScopeServer))
+		}))
+	}
+}
+
+func routesCmd(app *webapp.App) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "List the routes served by the web application",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			routes, err := app.Routes()
+			if err != nil {
+				return err
+			}
+
+			switch output {
+			case "json":
+				return writeJSON(cmd, routes)
+			case "table":
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "METHOD\tPATH\tMODULE\tHANDLER\tMIDDLEWARES")
+				for _, route := range routes {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+						route.Method,
+						route.Path,
+						orDash(route.Module),
+						route.Handler,
+						orDash(strings.Join(route.Middlewares, ", ")),
+					)
+				}
+				return w.Flush()
+			default:
+				return fmt.Errorf("unknown output format %s, use table or json", output)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format, table or json")
+	return cmd
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -7,7 +7,7 @@ import (
 
 func Server(app *webapp.App) func(settings *webapp.Settings) webapp.Module {
 	return func(settings *webapp.Settings) webapp.Module {
-		return webapp.NewModule(webapp.WithCLI(func(cmd *cobra.Command) {
+		return webapp.NewModule(webapp.WithName("server"), webapp.WithCLI(func(cmd *cobra.Command) {
 			startCmd := cobra.Command{
 				Use:   "start",
 				Short: "Start the web application",
@@ -15,7 +15,7 @@ func Server(app *webapp.App) func(settings *webapp.Settings) webapp.Module {
 					return app.Start(cmd.Context())
 				},
 			}
-			cmd.AddCommand(&startCmd)
+			cmd.AddCommand(webapp.WithCommandScopes(&startCmd, webapp.ScopeServer, webapp.ScopeDatabase))
 		}))
 	}
 }
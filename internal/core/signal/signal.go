@@ -16,6 +16,12 @@ type (
 	}
 )
 
+// NotifyContext returns a copy of the context cancelled on receiving any of
+// the watched signals, stop restores the default behavior of the signals
+func NotifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, signals...)
+}
+
 func NewSignalNotifier() *SignalNotifier {
 	return &SignalNotifier{
 		handlers: []SignalHandler{},
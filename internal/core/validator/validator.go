@@ -1,15 +1,58 @@
 package validator
 
-import "github.com/go-playground/validator/v10"
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
 
 // use a single instance of Validate, it caches struct info
 // and it is concurrent-safe
 var validate *validator.Validate
 
+// FieldError describes a single failed validation of a field, the field is
+// named after its json tag when set
+type FieldError struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Param string `json:"param,omitempty"`
+}
+
 func Validate(i interface{}) error {
 	return validate.Struct(i)
 }
 
+// FieldErrors extracts the failed validations from err, returns false when
+// the err is not a validation error
+func FieldErrors(err error) ([]FieldError, bool) {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil, false
+	}
+
+	fields := make([]FieldError, len(validationErrors))
+	for i, e := range validationErrors {
+		fields[i] = FieldError{
+			Field: e.Field(),
+			Tag:   e.Tag(),
+			Param: e.Param(),
+		}
+	}
+	return fields, true
+}
+
+// jsonFieldName names the fields as the clients know them
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
 func init() {
 	validate = validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(jsonFieldName)
 }
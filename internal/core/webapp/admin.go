@@ -0,0 +1,101 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	adminHealthPath    = "/health"
+	adminMetricsPath   = "/metrics"
+	healthCheckTimeout = 5 * time.Second
+
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+type (
+	HealthResponse struct {
+		Status    string                    `json:"status"`
+		Databases map[string]DatabaseHealth `json:"databases"`
+	}
+
+	DatabaseHealth struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	MetricsResponse struct {
+		Databases map[string]DatabaseMetrics `json:"databases"`
+	}
+
+	// DatabaseMetrics are the connection pool statistics of a database
+	DatabaseMetrics struct {
+		MaxOpenConnections int   `json:"max_open_connections"`
+		OpenConnections    int   `json:"open_connections"`
+		InUse              int   `json:"in_use"`
+		Idle               int   `json:"idle"`
+		WaitCount          int64 `json:"wait_count"`
+		WaitDurationMs     int64 `json:"wait_duration_ms"`
+		MaxIdleClosed      int64 `json:"max_idle_closed"`
+		MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+		MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+	}
+)
+
+// createAdminRoutes registers the admin endpoints under the prefix
+func createAdminRoutes(r chi.Router, prefix string, router chi.Routes, registry *routeRegistry) {
+	r.Get(prefix+adminRoutesPath, routesHandler(router, registry))
+	r.Get(prefix+adminHealthPath, healthHandler)
+	r.Get(prefix+adminMetricsPath, metricsHandler)
+}
+
+// healthHandler reports the health of every database, responds with 503
+// when any of them is unavailable
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	response := HealthResponse{
+		Status:    healthStatusOK,
+		Databases: make(map[string]DatabaseHealth),
+	}
+	for name, err := range DBHealth(ctx) {
+		if err != nil {
+			response.Status = healthStatusUnavailable
+			response.Databases[name] = DatabaseHealth{Status: healthStatusUnavailable, Error: err.Error()}
+			continue
+		}
+		response.Databases[name] = DatabaseHealth{Status: healthStatusOK}
+	}
+
+	status := http.StatusOK
+	if response.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	WriteJSON(w, response, status)
+}
+
+// metricsHandler reports the connection pool statistics of every database
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	response := MetricsResponse{
+		Databases: make(map[string]DatabaseMetrics),
+	}
+	for name, stats := range DBStats() {
+		response.Databases[name] = DatabaseMetrics{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		}
+	}
+	WriteJSON(w, response)
+}
@@ -2,8 +2,10 @@ package webapp
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
@@ -20,7 +22,11 @@ type (
 
 		registry           registry
 		modules            []Module
+		initialized        []Module
+		commandOwners      map[*cobra.Command]Module
+		routes             *routeRegistry
 		defaultMiddlewares []Middleware
+		idempotencyScope   IdempotencyScope
 	}
 
 	Middleware func(http.Handler) http.Handler
@@ -36,6 +42,14 @@ func WithDefaultMiddlewares(middlewares ...Middleware) Option {
 	}
 }
 
+// WithIdempotencyScope scopes the idempotency keys by the caller, default to
+// AuthorizationScope
+func WithIdempotencyScope(scope IdempotencyScope) Option {
+	return func(a *App) {
+		a.idempotencyScope = scope
+	}
+}
+
 func NewApp(name string, shortName string, opts ...Option) *App {
 	app := App{
 		name:               name,
@@ -66,36 +80,43 @@ func (a *App) Run(ctx context.Context) error {
 
 	// initialize logger
 	initializeLogger(settings.Log)
+	initializePagination(settings.Pagination)
 
-	// create and initialize modules
-	log.Trace("initializing modules...")
+	// create modules, they are initialized once the command is known
 	a.modules = make([]Module, len(a.registry))
 	for i, factory := range a.registry {
 		a.modules[i] = factory(&settings)
-		a.modules[i].Init(ctx)
 	}
 
-	rootCmd := a.initializeCli()
-	if err := rootCmd.ExecuteContext(ctx); err != nil {
+	// verify the capabilities of the modules before initializing them
+	if err := a.verifyModules(); err != nil {
 		return err
 	}
 
-	// close all modules
-	log.Trace("closing modules...")
-	for _, module := range a.modules {
-		module.Close()
-	}
-	return nil
+	rootCmd := a.initializeCli()
+	defer a.closeModules()
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func (a *App) Start(ctx context.Context) error {
-	// create and initialize server
+	// create and initialize server, the routes are registered once the modules
+	// are initialized and conflicting routes fail the startup
 	log.Info("starting the server...", log.WithField("addr", a.settings.Server.Addr))
-	server := a.createServer()
-	if err := initializeDB(a.settings.DB); err != nil {
+	server, err := a.createServer()
+	if err != nil {
 		return err
 	}
 
+	// the idempotency keys are stored in the default database
+	if a.settings.Idempotency.Enabled && !isDBOpened() {
+		return errors.New("idempotency requires the database module and a command in the database scope")
+	}
+
+	// the migrations are applied while initializing the modules, see initializeModules
+	if a.settings.Migration.AutoMigrate && !isDBOpened() {
+		return errors.New("auto migrate requires the database module and a command in the database scope")
+	}
+
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			panic(err)
@@ -111,20 +132,87 @@ func (a *App) Start(ctx context.Context) error {
 
 	// close the server within 120s
 	log.Info("closing the server...")
-	defer closeDB()
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel() // ensure no context leak on graceful shutdown
 	return server.Shutdown(ctx)
 }
 
+// autoMigrate applies the pending migrations of the modules, they are stopped
+// gracefully on receiving a signal
+func (a *App) autoMigrate(ctx context.Context) error {
+	sets, err := a.MigrationSets(a.settings.Migration.Path)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx)
+	defer stop()
+	return autoMigrate(ctx, a.settings.Migration, a.settings.DatabaseConnections(), sets)
+}
+
+// Migrations returns the go migrations of the modules, applied along the app migrations
+func (a *App) Migrations() []Migration {
+	var migrations []Migration
+	for _, module := range a.modules {
+		if service, ok := module.(MigrationService); ok {
+			migrations = append(migrations, service.Migrations()...)
+		}
+	}
+	return migrations
+}
+
+// MigrationSets returns the migration sets of the modules in dependency order
+// followed by the app migrations, read from the path when set
+func (a *App) MigrationSets(path string) ([]MigrationSet, error) {
+	var sets []MigrationSet
+	for _, module := range a.modules {
+		service, ok := module.(MigrationSetService)
+		if !ok {
+			continue
+		}
+
+		set := service.MigrationSet()
+		if set.FS == nil && len(set.Migrations) == 0 {
+			continue
+		}
+
+		// the set is named after its module by default
+		if named, ok := module.(NamedModule); ok && set.Name == "" {
+			set.Name = named.Name()
+		}
+		if set.Name == "" {
+			return nil, errors.New("migration set of an unnamed module requires a name")
+		}
+		sets = append(sets, set)
+	}
+
+	sets = append(sets, AppMigrations(path, a.Migrations()...))
+	return SortMigrationSets(sets)
+}
+
 func (a *App) initializeCli() *cobra.Command {
 	rootCmd := cobra.Command{
 		Use: a.name,
+		// commands overriding the persistent pre run must initialize the modules themselves
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return a.initializeModules(cmd)
+		},
 	}
 
-	for m := range a.modules {
-		if cli, ok := a.modules[m].(CLI); ok {
-			cli.Command(&rootCmd)
+	// track the module of every top level command
+	a.commandOwners = make(map[*cobra.Command]Module)
+	for _, module := range a.modules {
+		cli, ok := module.(CLI)
+		if !ok {
+			continue
+		}
+
+		existing := slices.Clone(rootCmd.Commands())
+		cli.Command(&rootCmd)
+		for _, cmd := range rootCmd.Commands() {
+			if !slices.Contains(existing, cmd) {
+				a.commandOwners[cmd] = module
+			}
 		}
 	}
 
@@ -132,7 +220,7 @@ func (a *App) initializeCli() *cobra.Command {
 }
 
 // internal createServer function
-func (a *App) createServer() http.Server {
+func (a *App) createServer() (http.Server, error) {
 	// use chi as the router
 	router := chi.NewRouter()
 
@@ -141,21 +229,69 @@ func (a *App) createServer() http.Server {
 		router.Use(middleware)
 	}
 
+	// count the queries of every request
+	if a.settings.QueryProfiler.Enabled {
+		router.Use(QueryProfiler(a.settings.QueryProfiler))
+	}
+
+	// negotiate the api version before routing
+	versions, err := a.collectAPIVersions()
+	if err != nil {
+		return http.Server{}, err
+	}
+	if len(versions) > 0 {
+		router.Use(apiVersionNegotiation(router, "/api", a.name, a.settings.API.VersionHeader, versions))
+	}
+
+	// record every route to detect conflicts between modules
+	routes := newRouteRegistry()
+	root := routes.router(router, appOwner, "").(*routeRecorder)
+
 	// register static routes
 	if a.settings.StaticServer.Enabled {
-		createStaticRoutes(router)
+		createStaticRoutes(root.forModule("static"))
+	}
+
+	// register admin routes
+	if a.settings.Admin.Enabled {
+		createAdminRoutes(root, a.settings.Admin.Prefix, router, routes)
+	}
+
+	// register root level routes
+	for _, module := range a.modules {
+		if service, ok := module.(RootService); ok {
+			routeModule(root, module, "", service.RootRoute)
+		}
 	}
 
 	// register routes
-	router.Route("/api", func(r chi.Router) {
+	root.Route("/api", func(r chi.Router) {
+		if a.settings.Idempotency.Enabled {
+			r.Use(Idempotency(a.settings.Idempotency, a.idempotencyScope))
+		}
+
+		// sub requests are dispatched through the root router
+		api := r.(*routeRecorder)
+		if a.settings.Batch.Enabled {
+			api.Post(batchPath, batchHandler(router, "/api", a.settings.Batch))
+		}
+
 		for _, module := range a.modules {
 			// register routes
 			if service, ok := module.(APIService); ok {
-				service.APIRoute(r)
+				routeModule(api, module, modulePrefix(module), service.APIRoute)
 			}
 		}
+
+		// register versioned routes
+		routeAPIVersions(api, versions, a.settings.API.VersionHeader)
 	})
 
+	a.routes = routes
+	if err := routes.Err(); err != nil {
+		return http.Server{}, err
+	}
+
 	// creates http server
 	// TODO: add https support
 	return http.Server{
@@ -164,7 +300,7 @@ func (a *App) createServer() http.Server {
 		ReadTimeout:  a.settings.Server.ReadTimeout,
 		WriteTimeout: a.settings.Server.WriteTimeout,
 		IdleTimeout:  a.settings.Server.IdleTimeout,
-	}
+	}, nil
 }
 
 func initializeLogger(settings LogSettings) {
@@ -0,0 +1,218 @@
+package webapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/go-chi/chi/v5"
+)
+
+const batchPath = "/batch"
+
+type (
+	// BatchRequest is the body of the batch endpoint
+	BatchRequest struct {
+		// Parallel executes the requests concurrently instead of in order
+		Parallel bool              `json:"parallel"`
+		Requests []BatchSubRequest `json:"requests"`
+	}
+
+	BatchSubRequest struct {
+		ID      string            `json:"id,omitempty"`
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    json.RawMessage   `json:"body,omitempty"`
+	}
+
+	BatchResponse struct {
+		Responses []BatchSubResponse `json:"responses"`
+	}
+
+	BatchSubResponse struct {
+		ID     string `json:"id,omitempty"`
+		Status int    `json:"status"`
+		// Headers keeps every value of the repeated headers, e.g. Set-Cookie
+		Headers http.Header `json:"headers,omitempty"`
+		Body    interface{} `json:"body,omitempty"`
+	}
+
+	// batchResponseWriter records the response of a sub request
+	batchResponseWriter struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// batchHandler dispatches the sub requests in-process through the handler
+// using the caller's headers and cookies
+func batchHandler(handler http.Handler, prefix string, settings BatchSettings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteError(w, NewHTTPError(http.StatusBadRequest, "invalid request body"))
+			return
+		}
+
+		if len(req.Requests) == 0 {
+			WriteError(w, NewHTTPError(http.StatusBadRequest, "no requests to execute"))
+			return
+		}
+
+		if settings.MaxRequests > 0 && len(req.Requests) > settings.MaxRequests {
+			WriteError(w, NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("batch is limited to %d requests", settings.MaxRequests)))
+			return
+		}
+
+		// only allow api paths, excluding the batch endpoint itself
+		for i, sub := range req.Requests {
+			u, err := url.Parse(sub.Path)
+			cleaned := ""
+			if err == nil {
+				cleaned = path.Clean(u.Path)
+			}
+
+			if !strings.HasPrefix(cleaned, prefix+"/") || cleaned == prefix+batchPath {
+				WriteError(w, NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("request %d has invalid path %s", i, sub.Path)))
+				return
+			}
+		}
+
+		responses := make([]BatchSubResponse, len(req.Requests))
+		if !req.Parallel {
+			for i, sub := range req.Requests {
+				responses[i] = dispatchBatch(handler, r, sub)
+			}
+			WriteJSON(w, BatchResponse{Responses: responses})
+			return
+		}
+
+		// limit the concurrency using a semaphore
+		concurrency := settings.Concurrency
+		if concurrency <= 0 {
+			concurrency = len(req.Requests)
+		}
+
+		var (
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, concurrency)
+		)
+		for i, sub := range req.Requests {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				responses[i] = dispatchBatch(handler, r, sub)
+			}()
+		}
+		wg.Wait()
+
+		WriteJSON(w, BatchResponse{Responses: responses})
+	}
+}
+
+// dispatchBatch serves the sub request, a panic of the handler results in a
+// 500 sub response as the parallel requests are not recovered by net/http
+func dispatchBatch(handler http.Handler, parent *http.Request, sub BatchSubRequest) (response BatchSubResponse) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Error("batch request panicked",
+				log.WithContext(parent.Context()),
+				log.WithField("method", sub.Method),
+				log.WithField("path", sub.Path),
+				log.WithField("panic", fmt.Sprint(v)),
+				log.WithField("stack", string(debug.Stack())),
+			)
+			response = BatchSubResponse{
+				ID:     sub.ID,
+				Status: http.StatusInternalServerError,
+				Body:   ErrorResponse{Error: "internal server error"},
+			}
+		}
+	}()
+
+	method := strings.ToUpper(sub.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// detach from the parent route context so the router resolves the sub request
+	ctx := context.WithValue(parent.Context(), chi.RouteCtxKey, nil)
+	req, err := http.NewRequestWithContext(ctx, method, sub.Path, bytes.NewReader(sub.Body))
+	if err != nil {
+		return BatchSubResponse{
+			ID:     sub.ID,
+			Status: http.StatusBadRequest,
+			Body:   ErrorResponse{Error: err.Error()},
+		}
+	}
+
+	// inherit the caller's headers and cookies
+	req.Header = parent.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Del(idempotencyHeader)
+	req.RemoteAddr = parent.RemoteAddr
+	req.Host = parent.Host
+	if len(sub.Body) > 0 {
+		req.Header.Set("Content-Type", ContentTypeJSON)
+	}
+	for k, v := range sub.Headers {
+		req.Header.Set(k, v)
+	}
+
+	recorder := batchResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(&recorder, req)
+
+	response = BatchSubResponse{
+		ID:      sub.ID,
+		Status:  recorder.status,
+		Headers: recorder.header,
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+
+	// embed JSON bodies as is, others as string
+	body := recorder.body.Bytes()
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		response.Body = json.RawMessage(bytes.TrimSpace(body))
+	default:
+		response.Body = string(body)
+	}
+
+	return response
+}
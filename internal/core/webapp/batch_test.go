@@ -0,0 +1,55 @@
+package webapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBatchHandler(t *testing.T) {
+	router := chi.NewRouter()
+	router.Route("/api", func(r chi.Router) {
+		r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+		r.Get("/cookies", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Set-Cookie", "a=1")
+			w.Header().Add("Set-Cookie", "b=2")
+			WriteJSON(w, map[string]string{"ok": "true"}, http.StatusCreated)
+		})
+	})
+	router.Post("/api/batch", batchHandler(router, "/api", BatchSettings{}))
+
+	for _, parallel := range []bool{false, true} {
+		body := `{"parallel":` + strconv.FormatBool(parallel) + `,"requests":[
+			{"id":"panic","method":"GET","path":"/api/panic"},
+			{"id":"cookies","method":"GET","path":"/api/cookies"}
+		]}`
+		r := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("parallel %v: expected 200, got %d: %s", parallel, w.Code, w.Body)
+		}
+
+		var response BatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		panicked, cookies := response.Responses[0], response.Responses[1]
+		if panicked.ID != "panic" || panicked.Status != http.StatusInternalServerError {
+			t.Fatalf("parallel %v: expected a 500 sub response, got %+v", parallel, panicked)
+		}
+		if cookies.Status != http.StatusCreated || !reflect.DeepEqual(cookies.Headers["Set-Cookie"], []string{"a=1", "b=2"}) {
+			t.Fatalf("parallel %v: unexpected sub response %+v", parallel, cookies)
+		}
+	}
+}
@@ -1,92 +1,347 @@
 package webapp
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-const defaultDbName = "default"
+const (
+	defaultDbName = "default"
+
+	defaultConnectBackoff    = 500 * time.Millisecond
+	defaultConnectMaxBackoff = 10 * time.Second
+)
 
 var (
 	// for holds all the database connections
-	dbInstances  map[string]*gorm.DB = make(map[string]*gorm.DB)
-	errNotOpened                     = errors.New("database not opened")
+	dbInstances map[string]*gorm.DB = make(map[string]*gorm.DB)
+	// dbMu guards the database connections and their replicas
+	dbMu sync.RWMutex
+
+	ErrDBNotOpened     = errors.New("database not opened")
+	ErrDBAlreadyOpened = errors.New("database already opened")
 )
 
-// DB returns the selected database connection by its name, default to default connection
-func DB(names ...string) *gorm.DB {
-	name := defaultDbName
-	if len(names) > 0 {
-		name = names[0]
-	}
+// Database manages the database connections as a module, they are opened for
+// the commands of the database scope and closed with the app, leave it out
+// when the app doesn't need a database
+func Database(settings *Settings) Module {
+	return NewModule(
+		WithName("database"),
+		WithScopes(ScopeDatabase),
+		WithInit(func(ctx context.Context) error {
+			return initializeDB(ctx, settings.DatabaseConnections())
+		}),
+		WithClose(func() error {
+			closeDB()
+			return nil
+		}),
+	)
+}
+
+// DB returns the selected database connection of the database module
+func (a *App) DB(names ...string) *gorm.DB {
+	return DB(names...)
+}
 
-	// ensure the database is opened
-	if _, ok := dbInstances[name]; !ok {
+// DB returns the selected database connection by its name, default to default connection,
+// panics when the database is not opened, use GetDB to handle the error instead
+func DB(names ...string) *gorm.DB {
+	db, err := GetDB(names...)
+	if err != nil {
 		log.Error("database not opened",
-			log.WithField("name", name),
-			log.WithError(errNotOpened),
+			log.WithField("name", dbName(names...)),
+			log.WithError(err),
 		)
 		// exit early
-		panic(errNotOpened)
+		panic(err)
 	}
 
-	return dbInstances[name]
+	return db
 }
 
+// GetDB returns the selected database connection or ErrDBNotOpened
+func GetDB(names ...string) (*gorm.DB, error) {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+
+	db, ok := dbInstances[dbName(names...)]
+	if !ok {
+		return nil, ErrDBNotOpened
+	}
+	return db, nil
+}
+
+// OpenDB opens the database and adds it to the opened connections
 func OpenDB(settings DatabaseSettings, names ...string) error {
+	return OpenDBContext(context.Background(), settings, names...)
+}
+
+// OpenDBContext opens the database and adds it to the opened connections,
+// the connect is retried with exponential backoff until the context is done
+func OpenDBContext(ctx context.Context, settings DatabaseSettings, names ...string) error {
+	name := dbName(names...)
+
+	// ensure the database is not already opened
+	if isDBOpened(name) {
+		return ErrDBAlreadyOpened
+	}
+
+	// fail early on invalid settings as they are not worth retrying
+	if _, err := dialector(settings); err != nil {
+		return err
+	}
+
 	var (
-		config gorm.Config
-		name   = defaultDbName
+		gormDb *gorm.DB
+		sqlDb  *sql.DB
+		err    error
 	)
+	for attempt := 0; ; attempt++ {
+		gormDb, sqlDb, err = openGorm(settings, name)
+		if err == nil || attempt >= settings.ConnectRetries {
+			break
+		}
 
-	// use supplied name if any
-	if len(names) > 0 {
-		name = names[0]
+		delay := connectBackoff(settings, attempt)
+		log.Warning("failed to connect to the database, retrying",
+			log.WithField("name", name),
+			log.WithField("attempt", attempt+1),
+			log.WithField("delay", delay.String()),
+			log.WithError(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	// ensure the database is not already opened
+	// route the reads to the replicas
+	var replicas *replicaSet
+	if len(settings.Replicas) > 0 {
+		replicas, err = useReplicas(gormDb, settings, name)
+		if err != nil {
+			sqlDb.Close()
+			return err
+		}
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	// opened concurrently in the meantime
 	if _, ok := dbInstances[name]; ok {
-		return errors.New("database already opened")
+		if replicas != nil {
+			replicas.close()
+		}
+		sqlDb.Close()
+		return ErrDBAlreadyOpened
+	}
+
+	dbInstances[name] = gormDb
+	if replicas != nil {
+		dbReplicas[name] = replicas
 	}
+	return nil
+}
+
+// CloseDB closes the database and removes it from the opened connections
+func CloseDB(names ...string) error {
+	name := dbName(names...)
 
-	// TODO: add gorm configurations
-	gormDb, err := gorm.Open(postgres.Open(settings.Uri), &config)
+	dbMu.Lock()
+	db, ok := dbInstances[name]
+	replicas := dbReplicas[name]
+	delete(dbInstances, name)
+	delete(dbReplicas, name)
+	dbMu.Unlock()
+
+	if !ok {
+		return ErrDBNotOpened
+	}
+
+	if replicas != nil {
+		replicas.close()
+	}
+
+	sqlDb, err := db.DB()
 	if err != nil {
 		return err
 	}
 
+	// close the underlying sql connection pool
+	return sqlDb.Close()
+}
+
+// openGorm opens the gorm connection of the settings with its pool configured,
+// the connection is established on the first use when lazy
+func openGorm(settings DatabaseSettings, name string) (*gorm.DB, *sql.DB, error) {
+	dialector, err := dialector(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := gormConfig(name, settings.Gorm)
+	config.DisableAutomaticPing = settings.LazyConnect
+	gormDb, err := gorm.Open(dialector, config)
+	if err != nil {
+		// the pool is left opened when the ping fails
+		closeGorm(gormDb)
+		return nil, nil, err
+	}
+
+	// only records the profiled requests, see QueryProfiler
+	if settings.queryProfiler {
+		if err := gormDb.Use(queryProfilerPlugin{}); err != nil {
+			closeGorm(gormDb)
+			return nil, nil, err
+		}
+	}
+
 	// configure connection pool
 	sqlDb, err := gormDb.DB()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	configurePool(sqlDb, settings)
+	return gormDb, sqlDb, nil
+}
+
+// closeGorm closes the connection pool of the partially opened gorm connection
+func closeGorm(gormDb *gorm.DB) {
+	if gormDb == nil {
+		return
 	}
+
+	if sqlDb, err := gormDb.DB(); err == nil {
+		sqlDb.Close()
+	}
+}
+
+// openConn opens the connection pool of the settings
+func openConn(settings DatabaseSettings, name string) (*sql.DB, error) {
+	_, sqlDb, err := openGorm(settings, name)
+	return sqlDb, err
+}
+
+func configurePool(sqlDb *sql.DB, settings DatabaseSettings) {
 	sqlDb.SetMaxIdleConns(settings.MaxIdleConns)
 	sqlDb.SetMaxOpenConns(settings.MaxOpenConns)
 	sqlDb.SetConnMaxLifetime(settings.ConnMaxLifetime)
+	sqlDb.SetConnMaxIdleTime(settings.ConnMaxIdleTime)
+}
 
-	dbInstances[name] = gormDb
+// connectBackoff returns the exponential backoff delay of the attempt with jitter
+func connectBackoff(settings DatabaseSettings, attempt int) time.Duration {
+	backoff, maxBackoff := settings.ConnectBackoff, settings.ConnectMaxBackoff
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultConnectMaxBackoff
+	}
+
+	// avoid overflowing on many attempts
+	if attempt > 30 {
+		return maxBackoff
+	}
+	delay := backoff << attempt
+	delay += time.Duration(rand.Int64N(int64(delay)))
+	return min(delay, maxBackoff)
+}
+
+// initializeDB opens all the named databases, none is kept opened on failure
+func initializeDB(ctx context.Context, connections map[string]DatabaseSettings) error {
+	for name, settings := range connections {
+		if err := OpenDBContext(ctx, settings, name); err != nil {
+			closeDB()
+			return fmt.Errorf("failed to open database %s: %w", name, err)
+		}
+	}
 	return nil
 }
 
-func initializeDB(settings DatabaseSettings) error {
-	// TODO: support multiple databases
-	// TODO: support database other than postgres
-	return OpenDB(settings)
+// DBHealth pings every opened database, the error is nil for healthy ones
+func DBHealth(ctx context.Context) map[string]error {
+	dbMu.RLock()
+	instances := maps.Clone(dbInstances)
+	replicas := maps.Clone(dbReplicas)
+	dbMu.RUnlock()
+
+	health := make(map[string]error, len(instances))
+	for name, db := range instances {
+		sqlDb, err := db.DB()
+		if err == nil {
+			err = sqlDb.PingContext(ctx)
+		}
+		health[name] = err
+	}
+
+	for _, set := range replicas {
+		for _, r := range set.replicas {
+			health[r.name] = r.Err()
+		}
+	}
+	return health
 }
 
-func closeDB() {
+// DBStats returns the connection pool statistics of every opened database
+func DBStats() map[string]sql.DBStats {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+
+	stats := make(map[string]sql.DBStats, len(dbInstances))
 	for name, db := range dbInstances {
-		sqlDb, err := db.DB()
-		if err != nil {
-			// skip closing if there is an error
-			continue
+		if sqlDb, err := db.DB(); err == nil {
+			stats[name] = sqlDb.Stats()
 		}
+	}
+
+	for _, set := range dbReplicas {
+		for _, r := range set.replicas {
+			stats[r.name] = r.conn.Stats()
+		}
+	}
+	return stats
+}
+
+// isDBOpened checks whether the database connection is opened
+func isDBOpened(names ...string) bool {
+	_, err := GetDB(names...)
+	return err == nil
+}
+
+// dbName returns the supplied name, default to default connection
+func dbName(names ...string) string {
+	if len(names) > 0 {
+		return names[0]
+	}
+	return defaultDbName
+}
+
+// closeDB closes all the opened databases
+func closeDB() {
+	dbMu.RLock()
+	names := slices.Collect(maps.Keys(dbInstances))
+	dbMu.RUnlock()
 
-		// close the underlying sql connection pool
-		if err := sqlDb.Close(); err != nil {
+	for _, name := range names {
+		if err := CloseDB(name); err != nil && !errors.Is(err, ErrDBNotOpened) {
 			log.Error("failed to close the database connection",
 				log.WithField("name", name),
 				log.WithError(err),
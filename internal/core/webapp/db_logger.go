@@ -0,0 +1,158 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const defaultSlowThreshold = 200 * time.Millisecond
+
+// dbLogger writes the gorm logs through the log package, the queries are
+// logged at info level, slow queries as warning and failed ones as error
+type dbLogger struct {
+	name              string
+	level             gormlogger.LogLevel
+	slowThreshold     time.Duration
+	logParams         bool
+	logRecordNotFound bool
+}
+
+func newDBLogger(name string, settings GormSettings) *dbLogger {
+	logger := dbLogger{
+		name:              name,
+		level:             parseGormLogLevel(settings.LogLevel),
+		slowThreshold:     settings.SlowThreshold,
+		logParams:         settings.LogParams,
+		logRecordNotFound: settings.LogRecordNotFound,
+	}
+
+	if logger.slowThreshold <= 0 {
+		logger.slowThreshold = defaultSlowThreshold
+	}
+	return &logger
+}
+
+// LogMode implements gorm logger.Interface
+func (l *dbLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	logger := *l
+	logger.level = level
+	return &logger
+}
+
+// Info implements gorm logger.Interface
+func (l *dbLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Info {
+		log.Info(fmt.Sprintf(msg, data...), l.options(ctx)...)
+	}
+}
+
+// Warn implements gorm logger.Interface
+func (l *dbLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		log.Warning(fmt.Sprintf(msg, data...), l.options(ctx)...)
+	}
+}
+
+// Error implements gorm logger.Interface
+func (l *dbLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Error {
+		log.Error(fmt.Sprintf(msg, data...), l.options(ctx)...)
+	}
+}
+
+// Trace implements gorm logger.Interface
+func (l *dbLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	query := func() []log.Option {
+		sql, rows := fc()
+		return append(l.options(ctx),
+			log.WithField("sql", sql),
+			log.WithField("rows", rows),
+			log.WithField("elapsed", elapsed.String()),
+		)
+	}
+
+	switch {
+	case err != nil && l.level >= gormlogger.Error &&
+		(l.logRecordNotFound || !errors.Is(err, gorm.ErrRecordNotFound)):
+		log.Error("query failed", append(query(), log.WithError(err))...)
+	case elapsed > l.slowThreshold && l.level >= gormlogger.Warn:
+		log.Warning("slow query", append(query(), log.WithField("threshold", l.slowThreshold.String()))...)
+	case l.level >= gormlogger.Info:
+		log.Info("query", query()...)
+	}
+}
+
+// ParamsFilter implements gorm.ParamsFilter, the parameters are redacted
+// from the logged query unless enabled
+func (l *dbLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.logParams {
+		return sql, params
+	}
+	return sql, nil
+}
+
+func (l *dbLogger) options(ctx context.Context) []log.Option {
+	return []log.Option{
+		log.WithContext(ctx),
+		log.WithField("db", l.name),
+		log.WithField("source", querySource()),
+	}
+}
+
+// querySource returns the caller of the query outside gorm and this logger
+func querySource() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.File, "gorm.io/") && !strings.HasSuffix(frame.File, "/db_logger.go") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+// gormConfig returns the gorm configuration of the settings
+func gormConfig(name string, settings GormSettings) *gorm.Config {
+	return &gorm.Config{
+		Logger:                 newDBLogger(name, settings),
+		PrepareStmt:            settings.PrepareStmt,
+		SkipDefaultTransaction: settings.SkipDefaultTransaction,
+		TranslateError:         settings.TranslateError,
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix:   settings.TablePrefix,
+			SingularTable: settings.SingularTable,
+		},
+	}
+}
+
+func parseGormLogLevel(level string) gormlogger.LogLevel {
+	switch strings.ToLower(level) {
+	case "silent":
+		return gormlogger.Silent
+	case "error":
+		return gormlogger.Error
+	case "info":
+		return gormlogger.Info
+	default:
+		return gormlogger.Warn
+	}
+}
@@ -0,0 +1,120 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConnectBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings DatabaseSettings
+		attempt  int
+		min, max time.Duration
+	}{
+		{"default first attempt", DatabaseSettings{}, 0, defaultConnectBackoff, 2 * defaultConnectBackoff},
+		{"default capped", DatabaseSettings{}, 10, defaultConnectMaxBackoff, defaultConnectMaxBackoff},
+		{"doubled per attempt", DatabaseSettings{ConnectBackoff: 10 * time.Millisecond, ConnectMaxBackoff: time.Minute}, 3, 80 * time.Millisecond, 160 * time.Millisecond},
+		{"capped by max backoff", DatabaseSettings{ConnectBackoff: time.Second, ConnectMaxBackoff: 3 * time.Second}, 2, 3 * time.Second, 3 * time.Second},
+		{"no overflow", DatabaseSettings{ConnectBackoff: time.Second, ConnectMaxBackoff: time.Minute}, 100, time.Minute, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the jitter adds up to the delay itself
+			for range 20 {
+				delay := connectBackoff(test.settings, test.attempt)
+				if delay < test.min || delay > test.max {
+					t.Fatalf("expected a delay between %s and %s, got %s", test.min, test.max, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenDBRetries(t *testing.T) {
+	// the database file can't be created in a missing directory
+	unreachable := DatabaseSettings{
+		Uri:               "sqlite://" + filepath.Join(t.TempDir(), "missing", "app.db"),
+		ConnectRetries:    2,
+		ConnectBackoff:    time.Millisecond,
+		ConnectMaxBackoff: time.Millisecond,
+	}
+
+	tests := []struct {
+		name     string
+		settings DatabaseSettings
+		cancel   bool
+		err      error
+		elapsed  time.Duration
+	}{
+		{"retries exhausted", unreachable, false, nil, 2 * time.Millisecond},
+		{"cancelled while retrying", unreachable, true, context.Canceled, 0},
+		{"invalid settings", DatabaseSettings{Uri: "unknown://app"}, false, nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+
+			start := time.Now()
+			err := OpenDBContext(ctx, test.settings, "retried")
+			if err == nil {
+				CloseDB("retried")
+				t.Fatal("expected the database not to be opened")
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			// every retry waits for the backoff
+			if elapsed := time.Since(start); elapsed < test.elapsed {
+				t.Fatalf("expected the retries to take at least %s, got %s", test.elapsed, elapsed)
+			}
+			if isDBOpened("retried") {
+				t.Fatal("expected the failed database not to be kept")
+			}
+		})
+	}
+}
+
+func TestNamedDB(t *testing.T) {
+	settings := DatabaseSettings{Uri: "sqlite://:memory:"}
+	if err := OpenDB(settings, "named"); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB("named")
+
+	if _, err := GetDB("named"); err != nil {
+		t.Fatalf("expected the opened database, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		err  error
+	}{
+		{"get unknown", func() error { _, err := GetDB("unknown"); return err }, ErrDBNotOpened},
+		{"open twice", func() error { return OpenDB(settings, "named") }, ErrDBAlreadyOpened},
+		{"close unknown", func() error { return CloseDB("unknown") }, ErrDBNotOpened},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	// closed databases are no longer found
+	if err := CloseDB("named"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetDB("named"); !errors.Is(err, ErrDBNotOpened) {
+		t.Fatalf("expected %v after closing, got %v", ErrDBNotOpened, err)
+	}
+}
@@ -0,0 +1,152 @@
+package webapp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	// pure go sqlite driver, shared with the sqlite migration driver
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+
+	sqliteDriverName = "sqlite"
+)
+
+// MigrationFS holds the embedded migrations, the root holds the driver directories
+var MigrationFS fs.FS
+
+// driverSchemes maps the DSN schemes to their driver
+var driverSchemes = map[string]string{
+	"postgres":   DriverPostgres,
+	"postgresql": DriverPostgres,
+	"mysql":      DriverMySQL,
+	"sqlite":     DriverSQLite,
+	"sqlite3":    DriverSQLite,
+	"file":       DriverSQLite,
+}
+
+// DriverName returns the configured driver, otherwise the one of the DSN scheme
+func (s DatabaseSettings) DriverName() (string, error) {
+	if s.Driver != "" {
+		if driver, ok := driverSchemes[strings.ToLower(s.Driver)]; ok {
+			return driver, nil
+		}
+		return "", fmt.Errorf("unsupported database driver %s", s.Driver)
+	}
+
+	scheme, _, ok := strings.Cut(s.Uri, ":")
+	if !ok {
+		return "", fmt.Errorf("database uri has no scheme, set the driver explicitly")
+	}
+
+	driver, ok := driverSchemes[strings.ToLower(scheme)]
+	if !ok {
+		return "", fmt.Errorf("unsupported database scheme %s", scheme)
+	}
+	return driver, nil
+}
+
+// MigrationURL returns the database url understood by the migration drivers
+func (s DatabaseSettings) MigrationURL() (string, error) {
+	driver, err := s.DriverName()
+	if err != nil {
+		return "", err
+	}
+
+	switch driver {
+	case DriverMySQL:
+		// allow multiple statements in a migration file
+		return DriverMySQL + "://" + withDSNParam(trimScheme(s.Uri, "mysql"), "multiStatements", "true"), nil
+	case DriverSQLite:
+		// the path of the file: uris is only understood by the sqlite driver
+		path := trimScheme(s.Uri, "sqlite", "sqlite3")
+		if rest, ok := strings.CutPrefix(path, "file:"); ok {
+			path = strings.TrimPrefix(rest, "//")
+		}
+		return DriverSQLite + "://" + path, nil
+	default:
+		return s.Uri, nil
+	}
+}
+
+// dialector returns the gorm dialector of the driver
+func dialector(settings DatabaseSettings) (gorm.Dialector, error) {
+	driver, err := settings.DriverName()
+	if err != nil {
+		return nil, err
+	}
+
+	switch driver {
+	case DriverMySQL:
+		// scan the date and time columns into time.Time, the server version
+		// is queried on open unless connecting lazily
+		return mysql.New(mysql.Config{
+			DSN:                       withDSNParam(trimScheme(settings.Uri, "mysql"), "parseTime", "true"),
+			SkipInitializeWithVersion: settings.LazyConnect,
+		}), nil
+	case DriverSQLite:
+		return sqlite.Dialector{
+			DriverName: sqliteDriverName,
+			DSN:        trimScheme(settings.Uri, "sqlite", "sqlite3"),
+		}, nil
+	default:
+		return postgres.Open(settings.Uri), nil
+	}
+}
+
+// MigrationDir returns the migration directory of the driver when exists,
+// e.g. db/migrations/sqlite, otherwise the base directory
+func MigrationDir(base string, driver string) string {
+	dir := filepath.Join(base, driver)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return base
+}
+
+// MigrationFSDir returns the migration directory of the driver in the file
+// system when exists, otherwise its root
+func MigrationFSDir(fsys fs.FS, driver string) string {
+	if info, err := fs.Stat(fsys, driver); err == nil && info.IsDir() {
+		return driver
+	}
+	return "."
+}
+
+// trimScheme removes the scheme of the uri, e.g. sqlite://app.db to app.db
+func trimScheme(uri string, schemes ...string) string {
+	for _, scheme := range schemes {
+		if len(uri) > len(scheme)+3 && strings.EqualFold(uri[:len(scheme)+3], scheme+"://") {
+			return uri[len(scheme)+3:]
+		}
+	}
+	return uri
+}
+
+// withDSNParam adds the query param to the DSN unless already set
+func withDSNParam(dsn string, key string, value string) string {
+	_, query, _ := strings.Cut(dsn, "?")
+	for _, param := range strings.Split(query, "&") {
+		if name, _, _ := strings.Cut(param, "="); name == key {
+			return dsn
+		}
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + key + "=" + value
+}
@@ -0,0 +1,53 @@
+package webapp
+
+import "testing"
+
+func TestDriverName(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings DatabaseSettings
+		driver   string
+		valid    bool
+	}{
+		{"postgres", DatabaseSettings{Uri: "postgres://localhost/app"}, DriverPostgres, true},
+		{"postgresql", DatabaseSettings{Uri: "postgresql://localhost/app"}, DriverPostgres, true},
+		{"mysql", DatabaseSettings{Uri: "mysql://user@tcp(localhost)/app"}, DriverMySQL, true},
+		{"sqlite", DatabaseSettings{Uri: "sqlite://app.db"}, DriverSQLite, true},
+		{"sqlite3", DatabaseSettings{Uri: "SQLITE3://app.db"}, DriverSQLite, true},
+		{"file uri", DatabaseSettings{Uri: "file:app.db?cache=shared"}, DriverSQLite, true},
+		{"explicit driver", DatabaseSettings{Driver: "MySQL", Uri: "user@tcp(localhost)/app"}, DriverMySQL, true},
+		{"unsupported driver", DatabaseSettings{Driver: "oracle"}, "", false},
+		{"unsupported scheme", DatabaseSettings{Uri: "oracle://localhost/app"}, "", false},
+		{"no scheme", DatabaseSettings{Uri: "app.db"}, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver, err := test.settings.DriverName()
+			if (err == nil) != test.valid || driver != test.driver {
+				t.Fatalf("expected %q valid %v, got %q %v", test.driver, test.valid, driver, err)
+			}
+		})
+	}
+}
+
+func TestMigrationURL(t *testing.T) {
+	tests := []struct {
+		uri string
+		url string
+	}{
+		{"postgres://localhost/app?sslmode=disable", "postgres://localhost/app?sslmode=disable"},
+		{"mysql://user@tcp(localhost)/app", "mysql://user@tcp(localhost)/app?multiStatements=true"},
+		{"mysql://user@tcp(localhost)/app?parseTime=true", "mysql://user@tcp(localhost)/app?parseTime=true&multiStatements=true"},
+		{"mysql://user@tcp(localhost)/app?multiStatements=false", "mysql://user@tcp(localhost)/app?multiStatements=false"},
+		{"sqlite://app.db", "sqlite://app.db"},
+		{"sqlite3:///var/lib/app.db", "sqlite:///var/lib/app.db"},
+		{"file:app.db?_pragma=busy_timeout(5000)", "sqlite://app.db?_pragma=busy_timeout(5000)"},
+		{"file:///var/lib/app.db?mode=rwc", "sqlite:///var/lib/app.db?mode=rwc"},
+	}
+	for _, test := range tests {
+		url, err := DatabaseSettings{Uri: test.uri}.MigrationURL()
+		if err != nil || url != test.url {
+			t.Errorf("%s: expected %s, got %s %v", test.uri, test.url, url, err)
+		}
+	}
+}
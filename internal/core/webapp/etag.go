@@ -0,0 +1,197 @@
+package webapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const versionColumn = "version"
+
+var ErrVersionConflict = errors.New("version conflict")
+
+type (
+	// Versioner is implemented by models that opt-in to optimistic concurrency,
+	// usually by embedding Versioned
+	Versioner interface {
+		GetVersion() int64
+		SetVersion(version int64)
+	}
+
+	// Versioned adds the version column convention to a GORM model. The version
+	// is managed by UpdateVersioned, a resource rejects a body setting another
+	// version than the stored one as it is modified concurrently
+	Versioned struct {
+		Version int64 `gorm:"column:version;not null;default:1" json:"version"`
+	}
+
+	// VersionConflictError is returned when the row version changed since it was read
+	VersionConflictError struct {
+		Version int64
+	}
+)
+
+func (v *Versioned) GetVersion() int64 {
+	return v.Version
+}
+
+func (v *Versioned) SetVersion(version int64) {
+	v.Version = version
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: version %d is outdated", ErrVersionConflict, e.Version)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// ETag returns the strong entity tag of the item, derived from the version
+// for versioned models and from its JSON representation otherwise
+func ETag(item interface{}) (string, error) {
+	if versioner, ok := item.(Versioner); ok {
+		return fmt.Sprintf(`"v%d"`, versioner.GetVersion()), nil
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"h%s"`, hex.EncodeToString(sum[:16])), nil
+}
+
+// CheckPreconditions evaluates If-Match and If-None-Match against the current
+// etag, it writes 304 or 412 and returns false when the request must not proceed
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, etag string) bool {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchETag(ifMatch, etag, false) {
+		WriteError(w, NewHTTPError(http.StatusPreconditionFailed, "resource has been modified"))
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && matchETag(ifNoneMatch, etag, true) {
+		if safe {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			WriteError(w, NewHTTPError(http.StatusPreconditionFailed, "resource already exists"))
+		}
+		return false
+	}
+
+	return true
+}
+
+// UpdateVersioned updates the columns of current with the values of updated,
+// all columns are updated when none selected. For versioned models the update
+// only succeeds when the version still matches and the version is incremented,
+// otherwise it fails with VersionConflictError
+func UpdateVersioned[T any](db *gorm.DB, current *T, updated *T, columns ...string) error {
+	return updateVersioned(db, current, updated, columns)
+}
+
+// DeleteVersioned deletes the item only when its version still matches
+func DeleteVersioned[T any](db *gorm.DB, item *T) error {
+	versioner, ok := any(item).(Versioner)
+	if !ok {
+		return db.Delete(item).Error
+	}
+
+	version := versioner.GetVersion()
+	tx := db.Where(clause.Eq{Column: clause.Column{Name: versionColumn}, Value: version}).Delete(item)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return &VersionConflictError{Version: version}
+	}
+	return nil
+}
+
+// checkVersion fails with VersionConflictError when the updated item holds
+// another version than the current one, e.g. read before a concurrent update
+func checkVersion(current interface{}, updated interface{}) error {
+	currentVersioner, ok := current.(Versioner)
+	if !ok {
+		return nil
+	}
+
+	updatedVersioner, ok := updated.(Versioner)
+	if !ok || updatedVersioner.GetVersion() == currentVersioner.GetVersion() {
+		return nil
+	}
+	return &VersionConflictError{Version: updatedVersioner.GetVersion()}
+}
+
+func updateVersioned(db *gorm.DB, current interface{}, updated interface{}, columns []string) error {
+	if len(columns) == 0 {
+		columns = []string{"*"}
+	}
+
+	versioner, ok := current.(Versioner)
+	if !ok {
+		return db.Model(current).Select(columns).Updates(updated).Error
+	}
+
+	version := versioner.GetVersion()
+	updatedVersioner, ok := updated.(Versioner)
+	if ok {
+		updatedVersioner.SetVersion(version + 1)
+	}
+
+	// copy the columns to avoid modifying the caller's slice
+	columns = append(columns[:len(columns):len(columns)], versionColumn)
+	tx := db.Model(current).
+		Where(clause.Eq{Column: clause.Column{Name: versionColumn}, Value: version}).
+		Select(columns).
+		Updates(updated)
+	if tx.Error == nil && tx.RowsAffected > 0 {
+		return nil
+	}
+
+	// revert the version as the update failed
+	if ok {
+		updatedVersioner.SetVersion(version)
+	}
+
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return &VersionConflictError{Version: version}
+}
+
+// matchETag checks whether the etag is listed in the header value
+func matchETag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		// weak comparison ignores the weak indicator
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type versionedItem struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Versioned
+}
+
+func TestResourceBodyVersion(t *testing.T) {
+	openTestDB(t, "etag", &versionedItem{})
+	if err := DB("etag").Create(&versionedItem{ID: 1, Title: "first", Versioned: Versioned{Version: 3}}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	NewResource(ResourceOptions[versionedItem]{DB: "etag"}).Mount(router, "/items")
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+		etag        string
+	}{
+		{"put stale version", http.MethodPut, ContentTypeJSON, `{"title":"stale","version":2}`, http.StatusConflict, ""},
+		{"patch stale version", http.MethodPatch, ContentTypeMergePatch, `{"title":"stale","version":2}`, http.StatusConflict, ""},
+		{"put current version", http.MethodPut, ContentTypeJSON, `{"title":"put","version":3}`, http.StatusOK, `"v4"`},
+		{"put without version", http.MethodPut, ContentTypeJSON, `{"title":"put"}`, http.StatusOK, `"v5"`},
+		{"patch current version", http.MethodPatch, ContentTypeMergePatch, `{"title":"patched","version":5}`, http.StatusOK, `"v6"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/items/1", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+			if test.etag != "" && w.Header().Get("ETag") != test.etag {
+				t.Fatalf("expected etag %s, got %s", test.etag, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
@@ -0,0 +1,554 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FilterEqual        FilterOperator = "=="
+	FilterNotEqual     FilterOperator = "!="
+	FilterGreater      FilterOperator = "=gt="
+	FilterGreaterEqual FilterOperator = "=ge="
+	FilterLess         FilterOperator = "=lt="
+	FilterLessEqual    FilterOperator = "=le="
+	FilterIn           FilterOperator = "=in="
+	FilterNotIn        FilterOperator = "=out="
+	FilterLike         FilterOperator = "=like="
+)
+
+const (
+	FilterString FilterType = iota
+	FilterInt
+	FilterFloat
+	FilterBool
+	FilterTime
+)
+
+const (
+	defaultFilterParam    = "filter"
+	defaultFilterMaxDepth = 8
+
+	likeEscape = "!"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+
+	// aliases of the comparison operators
+	filterOperatorAliases = map[string]FilterOperator{
+		"<":  FilterLess,
+		"<=": FilterLessEqual,
+		">":  FilterGreater,
+		">=": FilterGreaterEqual,
+	}
+
+	filterOperators = []FilterOperator{
+		FilterEqual, FilterNotEqual,
+		FilterGreater, FilterGreaterEqual,
+		FilterLess, FilterLessEqual,
+		FilterIn, FilterNotIn,
+		FilterLike,
+	}
+)
+
+type (
+	// FilterOperator is a comparison operator in the filter expression
+	FilterOperator string
+
+	// FilterType is used to convert the filter values before querying
+	FilterType int
+
+	// FilterOptions whitelists the fields and operators of a resource
+	FilterOptions struct {
+		// Fields is keyed by the name used in the expression
+		Fields map[string]FilterField
+		// Param is the query parameter holding the expression, default to filter
+		Param string
+		// MaxDepth limits the nesting of the expression, default to 8
+		MaxDepth int
+	}
+
+	// FilterField describes a filterable field
+	FilterField struct {
+		Column string
+		Type   FilterType
+		// Operators allowed for the field, all operators are allowed when empty
+		Operators []FilterOperator
+	}
+
+	// FilterNode is a node of the parsed filter expression
+	FilterNode interface {
+		filterNode()
+	}
+
+	// FilterLogical combines its children with AND or OR
+	FilterLogical struct {
+		Or       bool
+		Children []FilterNode
+	}
+
+	// FilterComparison compares a field with one or more values
+	FilterComparison struct {
+		Field    string
+		Operator FilterOperator
+		Values   []string
+	}
+
+	// FilterError describes an invalid filter expression
+	FilterError struct {
+		Pos int
+		Msg string
+	}
+
+	// Filter is a parsed and validated filter expression
+	Filter struct {
+		Root FilterNode
+
+		opts FilterOptions
+	}
+
+	filterParser struct {
+		input    string
+		pos      int
+		depth    int
+		maxDepth int
+	}
+)
+
+func (FilterLogical) filterNode()    {}
+func (FilterComparison) filterNode() {}
+
+func (e *FilterError) Error() string {
+	if e.Pos < 0 {
+		return fmt.Sprintf("%s: %s", ErrInvalidFilter, e.Msg)
+	}
+	return fmt.Sprintf("%s at position %d: %s", ErrInvalidFilter, e.Pos, e.Msg)
+}
+
+func (e *FilterError) Unwrap() error {
+	return ErrInvalidFilter
+}
+
+// ParseFilter parses and validates the filter expression of the request
+func ParseFilter(r *http.Request, opts FilterOptions) (*Filter, error) {
+	param := opts.Param
+	if param == "" {
+		param = defaultFilterParam
+	}
+
+	return NewFilter(r.URL.Query().Get(param), opts)
+}
+
+// NewFilter parses the expression and validates it against the options
+func NewFilter(expr string, opts FilterOptions) (*Filter, error) {
+	filter := Filter{opts: opts}
+	if strings.TrimSpace(expr) == "" {
+		return &filter, nil
+	}
+
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultFilterMaxDepth
+	}
+
+	root, err := parseFilterExpr(expr, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := filter.validate(root, 1, maxDepth); err != nil {
+		return nil, err
+	}
+
+	filter.Root = root
+	return &filter, nil
+}
+
+// ParseFilterExpr parses the expression into its AST without validation, the
+// parentheses are nested up to the default max depth
+func ParseFilterExpr(expr string) (FilterNode, error) {
+	return parseFilterExpr(expr, defaultFilterMaxDepth)
+}
+
+func parseFilterExpr(expr string, maxDepth int) (FilterNode, error) {
+	p := filterParser{input: expr, maxDepth: maxDepth}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+
+	return node, nil
+}
+
+// Scope returns a gorm scope that applies the filter as parameterized conditions
+func (f *Filter) Scope() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if f.Root == nil {
+			return db
+		}
+
+		expr, err := f.compile(f.Root)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		return db.Where(expr)
+	}
+}
+
+func (f *Filter) validate(node FilterNode, depth int, maxDepth int) error {
+	if depth > maxDepth {
+		return &FilterError{Pos: -1, Msg: fmt.Sprintf("nested deeper than %d levels", maxDepth)}
+	}
+
+	switch n := node.(type) {
+	case *FilterLogical:
+		for _, child := range n.Children {
+			if err := f.validate(child, depth+1, maxDepth); err != nil {
+				return err
+			}
+		}
+	case *FilterComparison:
+		field, ok := f.opts.Fields[n.Field]
+		if !ok {
+			return &FilterError{Pos: -1, Msg: fmt.Sprintf("field %s is not filterable", n.Field)}
+		}
+
+		if len(field.Operators) > 0 && !slices.Contains(field.Operators, n.Operator) {
+			return &FilterError{Pos: -1, Msg: fmt.Sprintf("operator %s is not allowed for %s", n.Operator, n.Field)}
+		}
+
+		multiple := n.Operator == FilterIn || n.Operator == FilterNotIn
+		if !multiple && len(n.Values) != 1 {
+			return &FilterError{Pos: -1, Msg: fmt.Sprintf("operator %s expects a single value", n.Operator)}
+		}
+
+		for _, v := range n.Values {
+			if _, err := field.convert(v); err != nil {
+				return &FilterError{Pos: -1, Msg: fmt.Sprintf("invalid value %q for %s", v, n.Field)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *Filter) compile(node FilterNode) (clause.Expression, error) {
+	switch n := node.(type) {
+	case *FilterLogical:
+		exprs := make([]clause.Expression, len(n.Children))
+		for i, child := range n.Children {
+			expr, err := f.compile(child)
+			if err != nil {
+				return nil, err
+			}
+			exprs[i] = expr
+		}
+
+		if n.Or {
+			return clause.Or(exprs...), nil
+		}
+		return clause.And(exprs...), nil
+	case *FilterComparison:
+		field := f.opts.Fields[n.Field]
+		values := make([]interface{}, len(n.Values))
+		for i, v := range n.Values {
+			converted, err := field.convert(v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = converted
+		}
+
+		column := clause.Column{Name: field.Column}
+		if column.Name == "" {
+			column.Name = n.Field
+		}
+
+		switch n.Operator {
+		case FilterEqual:
+			return clause.Eq{Column: column, Value: values[0]}, nil
+		case FilterNotEqual:
+			return clause.Neq{Column: column, Value: values[0]}, nil
+		case FilterGreater:
+			return clause.Gt{Column: column, Value: values[0]}, nil
+		case FilterGreaterEqual:
+			return clause.Gte{Column: column, Value: values[0]}, nil
+		case FilterLess:
+			return clause.Lt{Column: column, Value: values[0]}, nil
+		case FilterLessEqual:
+			return clause.Lte{Column: column, Value: values[0]}, nil
+		case FilterIn:
+			return clause.IN{Column: column, Values: values}, nil
+		case FilterNotIn:
+			return clause.Not(clause.IN{Column: column, Values: values}), nil
+		case FilterLike:
+			// the escape character differs between the drivers unless explicit
+			return clause.Expr{
+				SQL:  "? LIKE ? ESCAPE '" + likeEscape + "'",
+				Vars: []interface{}{column, likePattern(n.Values[0])},
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unsupported node %T", ErrInvalidFilter, node)
+}
+
+func (field FilterField) convert(value string) (interface{}, error) {
+	switch field.Type {
+	case FilterInt:
+		return strconv.ParseInt(value, 10, 64)
+	case FilterFloat:
+		return strconv.ParseFloat(value, 64)
+	case FilterBool:
+		return strconv.ParseBool(value)
+	case FilterTime:
+		// accept date only or full RFC3339 timestamp
+		if t, err := time.Parse(time.DateOnly, value); err == nil {
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, value)
+	default:
+		return value, nil
+	}
+}
+
+// likePattern converts * wildcards into sql wildcards, escaping the existing ones
+// with likeEscape, a backslash would need escaping in mysql string literals
+func likePattern(value string) string {
+	replacer := strings.NewReplacer(likeEscape, likeEscape+likeEscape,
+		"%", likeEscape+"%", "_", likeEscape+"_", "*", "%")
+	return replacer.Replace(value)
+}
+
+// parseOr parses comparisons separated by , (or)
+func (p *filterParser) parseOr() (FilterNode, error) {
+	return p.parseLogical(',', true, p.parseAnd)
+}
+
+// parseAnd parses comparisons separated by ; (and)
+func (p *filterParser) parseAnd() (FilterNode, error) {
+	return p.parseLogical(';', false, p.parseTerm)
+}
+
+func (p *filterParser) parseLogical(sep byte, or bool, next func() (FilterNode, error)) (FilterNode, error) {
+	node, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []FilterNode{node}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != sep {
+			break
+		}
+		p.pos++
+
+		node, err := next()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &FilterLogical{Or: or, Children: children}, nil
+}
+
+func (p *filterParser) parseTerm() (FilterNode, error) {
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		// stop before parsing deeply nested input
+		if p.depth++; p.depth > p.maxDepth {
+			return nil, p.errorf("nested deeper than %d levels", p.maxDepth)
+		}
+
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (FilterNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isSelectorChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, p.errorf("expected field name")
+	}
+	field := p.input[start:p.pos]
+
+	p.skipSpaces()
+	operator, err := p.parseOperator()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	values, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterComparison{Field: field, Operator: operator, Values: values}, nil
+}
+
+func (p *filterParser) parseOperator() (FilterOperator, error) {
+	rest := p.input[p.pos:]
+	start := p.pos
+
+	// check the aliases, longest first
+	for _, alias := range []string{"<=", ">=", "<", ">"} {
+		if strings.HasPrefix(rest, alias) {
+			p.pos += len(alias)
+			return filterOperatorAliases[alias], nil
+		}
+	}
+
+	if strings.HasPrefix(rest, "!=") {
+		p.pos += 2
+		return FilterNotEqual, nil
+	}
+
+	// parse =[a-z]*=
+	if !strings.HasPrefix(rest, "=") {
+		return "", p.errorf("expected operator")
+	}
+	end := strings.IndexByte(rest[1:], '=')
+	if end < 0 {
+		return "", p.errorf("expected operator")
+	}
+
+	operator := FilterOperator(rest[:end+2])
+	if !slices.Contains(filterOperators, operator) {
+		return "", &FilterError{Pos: start, Msg: fmt.Sprintf("unknown operator %s", operator)}
+	}
+	p.pos += len(operator)
+	return operator, nil
+}
+
+func (p *filterParser) parseArguments() ([]string, error) {
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+
+	p.pos++
+	var values []string
+	for {
+		p.skipSpaces()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpaces()
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("expected )")
+		}
+
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return values, nil
+		default:
+			return nil, p.errorf("expected , or )")
+		}
+	}
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	if p.pos >= len(p.input) {
+		return "", p.errorf("expected value")
+	}
+
+	// quoted value supports escaping with backslash
+	quote := p.input[p.pos]
+	if quote == '"' || quote == '\'' {
+		var sb strings.Builder
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			switch {
+			case c == '\\' && p.pos+1 < len(p.input):
+				sb.WriteByte(p.input[p.pos+1])
+				p.pos += 2
+			case c == quote:
+				p.pos++
+				return sb.String(), nil
+			default:
+				sb.WriteByte(c)
+				p.pos++
+			}
+		}
+		return "", &FilterError{Pos: start, Msg: "unterminated string"}
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !isReservedChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected value")
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+func (p *filterParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isSelectorChar(c byte) bool {
+	return c == '_' || c == '.' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+func isReservedChar(c byte) bool {
+	return strings.IndexByte(`"'();,=!~<> `, c) >= 0
+}
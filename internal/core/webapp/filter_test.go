@@ -0,0 +1,211 @@
+package webapp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type filteredItem struct {
+	ID        int64
+	Name      string
+	Age       int
+	CreatedAt time.Time
+}
+
+var filterTestOptions = FilterOptions{
+	Fields: map[string]FilterField{
+		"name":    {Column: "name"},
+		"age":     {Column: "age", Type: FilterInt, Operators: []FilterOperator{FilterEqual, FilterGreater, FilterIn}},
+		"created": {Column: "created_at", Type: FilterTime},
+	},
+}
+
+func TestParseFilterExpr(t *testing.T) {
+	name := func(op FilterOperator, values ...string) *FilterComparison {
+		return &FilterComparison{Field: "name", Operator: op, Values: values}
+	}
+
+	tests := []struct {
+		expr string
+		node FilterNode
+	}{
+		{"name==a", name(FilterEqual, "a")},
+		{" name != a ", name(FilterNotEqual, "a")},
+		{"name<a", name(FilterLess, "a")},
+		{"name<=a", name(FilterLessEqual, "a")},
+		{"name>a", name(FilterGreater, "a")},
+		{"name>=a", name(FilterGreaterEqual, "a")},
+		{"name=ge=a", name(FilterGreaterEqual, "a")},
+		{"name=in=(a, b,c)", name(FilterIn, "a", "b", "c")},
+		{"name=out=(a)", name(FilterNotIn, "a")},
+		{`name=="a b;c"`, name(FilterEqual, "a b;c")},
+		{`name=='it\'s'`, name(FilterEqual, "it's")},
+		{"name=like=a*", name(FilterLike, "a*")},
+		{"name==a;name==b,name==c", &FilterLogical{Or: true, Children: []FilterNode{
+			&FilterLogical{Children: []FilterNode{name(FilterEqual, "a"), name(FilterEqual, "b")}},
+			name(FilterEqual, "c"),
+		}}},
+		{"name==a;(name==b,name==c)", &FilterLogical{Children: []FilterNode{
+			name(FilterEqual, "a"),
+			&FilterLogical{Or: true, Children: []FilterNode{name(FilterEqual, "b"), name(FilterEqual, "c")}},
+		}}},
+		{"((name==a))", name(FilterEqual, "a")},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			node, err := ParseFilterExpr(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(node, test.node) {
+				t.Fatalf("parsed %#v, expected %#v", node, test.node)
+			}
+		})
+	}
+}
+
+func TestParseFilterExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{"", 0},
+		{"==a", 0},
+		{"name", 4},
+		{"name=a", 4},
+		{"name=foo=a", 4},
+		{"name==", 6},
+		{"name==a)", 7},
+		{"(name==a", 8},
+		{"name=in=(a", 10},
+		{"name=in=(a;b)", 10},
+		{`name=="a`, 6},
+		{"name==a;", 8},
+		{strings.Repeat("(", 9) + "name==a" + strings.Repeat(")", 9), 8},
+		// rejected before parsing the whole input
+		{strings.Repeat("(", 100000), 8},
+	}
+	for _, test := range tests {
+		t.Run(test.expr[:min(len(test.expr), 20)], func(t *testing.T) {
+			_, err := ParseFilterExpr(test.expr)
+
+			var filterErr *FilterError
+			if !errors.As(err, &filterErr) || !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("expected a filter error, got %v", err)
+			}
+			if filterErr.Pos != test.pos {
+				t.Fatalf("expected the error at %d, got %v", test.pos, err)
+			}
+		})
+	}
+}
+
+func TestNewFilterValidation(t *testing.T) {
+	tests := []struct {
+		expr string
+		opts FilterOptions
+		err  string
+	}{
+		{"name==a;age==1;created>2024-01-01", filterTestOptions, ""},
+		{"created>=2024-01-01T10:00:00Z", filterTestOptions, ""},
+		{"age=in=(1,2)", filterTestOptions, ""},
+		{"password==a", filterTestOptions, "field password is not filterable"},
+		{"age<1", filterTestOptions, "operator =lt= is not allowed for age"},
+		{"age==a", filterTestOptions, `invalid value "a" for age`},
+		{"created>yesterday", filterTestOptions, `invalid value "yesterday" for created`},
+		{"name=like=(a,b)", filterTestOptions, "operator =like= expects a single value"},
+		{"name==a;(name==b,name==c)", FilterOptions{Fields: filterTestOptions.Fields, MaxDepth: 3}, ""},
+		{"name==a;(name==b,(name==c;name==d))", FilterOptions{Fields: filterTestOptions.Fields, MaxDepth: 3}, "nested deeper than 3 levels"},
+		{"(((name==a)))", FilterOptions{Fields: filterTestOptions.Fields, MaxDepth: 2}, "nested deeper than 2 levels"},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := NewFilter(test.expr, test.opts)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatal(err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("expected %q, got %v", test.err, err)
+			case err != nil && !errors.Is(err, ErrInvalidFilter):
+				t.Fatalf("expected ErrInvalidFilter, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		value   string
+		pattern string
+	}{
+		{"abc", "abc"},
+		{"a*c", "a%c"},
+		{"100%", "100!%"},
+		{"a_b", "a!_b"},
+		{"hi!", "hi!!"},
+		{`a\b`, `a\b`},
+	}
+	for _, test := range tests {
+		if pattern := likePattern(test.value); pattern != test.pattern {
+			t.Errorf("likePattern(%q) = %q, expected %q", test.value, pattern, test.pattern)
+		}
+	}
+}
+
+func TestFilterScope(t *testing.T) {
+	db, err := gorm.Open(sqlite.Dialector{DriverName: sqliteDriverName, DSN: ":memory:"}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&filteredItem{}); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []filteredItem{
+		{ID: 1, Name: "100%", Age: 10, CreatedAt: day},
+		{ID: 2, Name: "1000", Age: 20, CreatedAt: day.AddDate(0, 0, 1)},
+		{ID: 3, Name: "a_b", Age: 30, CreatedAt: day.AddDate(0, 0, 2)},
+		{ID: 4, Name: "axb", Age: 40, CreatedAt: day.AddDate(0, 0, 3)},
+		{ID: 5, Name: `a\b!`, Age: 50, CreatedAt: day.AddDate(0, 0, 4)},
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		ids  []int64
+	}{
+		{"name=like=100%", []int64{1}},
+		{"name=like=100*", []int64{1, 2}},
+		{"name=like=a_b", []int64{3}},
+		{"name=like=a*b", []int64{3, 4}},
+		{`name=like='a\\b!'`, []int64{5}},
+		{"age>20;age=in=(30,40,50)", []int64{3, 4, 5}},
+		{"age==10,name=like=ax*", []int64{1, 4}},
+		{"created>2024-01-03", []int64{4, 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			filter, err := NewFilter(test.expr, filterTestOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []int64
+			if err := db.Model(&filteredItem{}).Scopes(filter.Scope()).Order("id").Pluck("id", &ids).Error; err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Fatalf("filtered %v, expected %v", ids, test.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package webapp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyMaxKeyLength   = 255
+	idempotencyCleanupPeriod  = time.Hour
+)
+
+type (
+	// IdempotencyScope returns the identity of the caller, the idempotency keys
+	// of a caller are never replayed to another one
+	IdempotencyScope func(r *http.Request) string
+
+	// idempotencyRecord is stored in the idempotency_keys table, status is zero
+	// while the request is still being processed. The key is hashed along the
+	// scope of the caller, the lock identifies the request processing it
+	idempotencyRecord struct {
+		Key         string `gorm:"column:idempotency_key;primaryKey"`
+		Fingerprint string
+		LockToken   string
+		Status      int
+		Header      []byte
+		Body        []byte
+		CreatedAt   time.Time
+		ExpiresAt   time.Time
+	}
+
+	// recordingResponseWriter captures the response while writing it through,
+	// it supports flushing and hijacking when the wrapped writer does
+	recordingResponseWriter struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+	}
+)
+
+func (idempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (w *recordingResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, the hijacked response is not recorded
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AuthorizationScope scopes the idempotency keys by the Authorization header
+func AuthorizationScope(r *http.Request) string {
+	return r.Header.Get("Authorization")
+}
+
+// Idempotency honors the Idempotency-Key header of POST and PATCH requests,
+// the response is stored in the default database and replayed on retries of
+// the same caller. The keys are scoped using AuthorizationScope when the scope
+// is nil
+func Idempotency(settings IdempotencySettings, scope IdempotencyScope) Middleware {
+	var lastCleanup atomic.Int64
+	if scope == nil {
+		scope = AuthorizationScope
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > idempotencyMaxKeyLength {
+				WriteError(w, NewHTTPError(http.StatusBadRequest, "idempotency key is too long"))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				WriteError(w, NewHTTPError(http.StatusBadRequest, "invalid request body"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// remove the expired keys periodically
+			now := time.Now()
+			if last := lastCleanup.Load(); now.Sub(time.Unix(last, 0)) > idempotencyCleanupPeriod &&
+				lastCleanup.CompareAndSwap(last, now.Unix()) {
+				go cleanupIdempotencyKeys()
+			}
+
+			db, err := GetDB()
+			if err != nil {
+				log.Error("idempotency database not opened",
+					log.WithContext(r.Context()),
+					log.WithError(err),
+				)
+				WriteError(w, NewHTTPError(http.StatusInternalServerError, "internal server error"))
+				return
+			}
+
+			db = db.WithContext(r.Context())
+			record := idempotencyRecord{
+				Key:         idempotencyKey(scope(r), key),
+				Fingerprint: idempotencyFingerprint(r, body),
+				ExpiresAt:   now.Add(settings.TTL),
+			}
+
+			acquired, err := acquireIdempotencyKey(db, &record, settings.LockTimeout)
+			if err != nil {
+				log.Error("failed to acquire idempotency key",
+					log.WithContext(r.Context()),
+					log.WithError(err),
+				)
+				WriteError(w, NewHTTPError(http.StatusInternalServerError, "internal server error"))
+				return
+			}
+
+			if !acquired {
+				replayIdempotentResponse(w, db, &record)
+				return
+			}
+
+			// keep storing the response even when the client is gone
+			db = db.WithContext(context.WithoutCancel(r.Context()))
+			recorder := recordingResponseWriter{ResponseWriter: w}
+			defer func() {
+				// the key may be taken over once the lock expired, only the
+				// request holding the lock finalizes it
+				inProgress := db.Where(clause.Eq{Column: clause.Column{Name: "status"}, Value: 0}).
+					Where(clause.Eq{Column: clause.Column{Name: "lock_token"}, Value: record.LockToken})
+
+				// release the key on failure so the client can retry
+				if v := recover(); v != nil || recorder.status >= http.StatusInternalServerError {
+					inProgress.Delete(&record)
+					if v != nil {
+						panic(v)
+					}
+					return
+				}
+
+				// nothing written is an empty 200 response
+				status := recorder.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				header, _ := json.Marshal(storedHeader(recorder.Header()))
+				err := inProgress.Model(&record).Updates(map[string]interface{}{
+					"status": status,
+					"header": header,
+					"body":   recorder.body.Bytes(),
+				}).Error
+				if err != nil {
+					log.Error("failed to store idempotent response",
+						log.WithContext(r.Context()),
+						log.WithError(err),
+					)
+				}
+			}()
+
+			next.ServeHTTP(&recorder, r)
+		})
+	}
+}
+
+// acquireIdempotencyKey inserts the record or takes over an expired one or one
+// still in progress after the lock timeout, e.g. left by a crashed process.
+// Returns false when the key is already used
+func acquireIdempotencyKey(db *gorm.DB, record *idempotencyRecord, lockTimeout time.Duration) (bool, error) {
+	lock := make([]byte, 16)
+	if _, err := rand.Read(lock); err != nil {
+		return false, err
+	}
+	record.LockToken = hex.EncodeToString(lock)
+
+	tx := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	if tx.RowsAffected > 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+	takeover := clause.Expression(clause.Lt{Column: clause.Column{Name: "expires_at"}, Value: now})
+	if lockTimeout > 0 {
+		takeover = clause.Or(takeover, clause.And(
+			clause.Eq{Column: clause.Column{Name: "status"}, Value: 0},
+			clause.Lt{Column: clause.Column{Name: "created_at"}, Value: now.Add(-lockTimeout)},
+		))
+	}
+
+	tx = db.Model(&idempotencyRecord{}).
+		Where(clause.Eq{Column: clause.Column{Name: "idempotency_key"}, Value: record.Key}).
+		Where(takeover).
+		Updates(map[string]interface{}{
+			"fingerprint": record.Fingerprint,
+			"lock_token":  record.LockToken,
+			"status":      0,
+			"header":      nil,
+			"body":        nil,
+			"created_at":  now,
+			"expires_at":  record.ExpiresAt,
+		})
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	return tx.RowsAffected > 0, nil
+}
+
+// replayIdempotentResponse writes the stored response of the key
+func replayIdempotentResponse(w http.ResponseWriter, db *gorm.DB, record *idempotencyRecord) {
+	var stored idempotencyRecord
+	err := db.Where(clause.Eq{Column: clause.Column{Name: "idempotency_key"}, Value: record.Key}).
+		First(&stored).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// released in the meantime, ask the client to retry
+			WriteError(w, NewHTTPError(http.StatusConflict, "request with the same idempotency key is in progress"))
+			return
+		}
+
+		WriteError(w, NewHTTPError(http.StatusInternalServerError, "internal server error"))
+		return
+	}
+
+	if stored.Fingerprint != record.Fingerprint {
+		WriteError(w, NewHTTPError(http.StatusUnprocessableEntity, "idempotency key is used with a different request"))
+		return
+	}
+
+	if stored.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		WriteError(w, NewHTTPError(http.StatusConflict, "request with the same idempotency key is in progress"))
+		return
+	}
+
+	var header http.Header
+	json.Unmarshal(stored.Header, &header)
+	for k, values := range header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+func cleanupIdempotencyKeys() {
+	db, err := GetDB()
+	if err != nil {
+		return
+	}
+
+	err = db.
+		Where(clause.Lt{Column: clause.Column{Name: "expires_at"}, Value: time.Now()}).
+		Delete(&idempotencyRecord{}).Error
+	if err != nil {
+		log.Error("failed to remove expired idempotency keys", log.WithError(err))
+	}
+}
+
+// idempotencyKey hashes the key of the caller along its scope
+func idempotencyKey(scope string, key string) string {
+	hash := sha256.New()
+	hash.Write([]byte(scope))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyFingerprint identifies the request by its method, path and body
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(r.URL.RequestURI()))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// storedHeader excludes headers that must not be replayed
+func storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	stored.Del("Set-Cookie")
+	stored.Del("Date")
+	return stored
+}
@@ -0,0 +1,155 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	openTestDB(t, defaultDbName, &idempotencyRecord{})
+
+	calls := 0
+	handler := Idempotency(IdempotencySettings{TTL: time.Hour, LockTimeout: time.Minute}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// nothing written is an empty 200 response
+			calls++
+		}),
+	)
+
+	send := func(key string, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{"id":1}`))
+		r.Header.Set(idempotencyHeader, key)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send("a", "Bearer alice"); w.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the handler response, got %d after %d calls", w.Code, calls)
+	}
+
+	// the retry is replayed instead of being in progress
+	w := send("a", "Bearer alice")
+	if w.Code != http.StatusOK || w.Header().Get(idempotencyReplayedHeader) != "true" || calls != 1 {
+		t.Fatalf("expected the replayed response, got %d after %d calls", w.Code, calls)
+	}
+
+	// the same key of another caller is not replayed
+	w = send("a", "Bearer mallory")
+	if w.Code != http.StatusOK || w.Header().Get(idempotencyReplayedHeader) != "" || calls != 2 {
+		t.Fatalf("expected a new response, got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyLockTimeout(t *testing.T) {
+	openTestDB(t, defaultDbName, &idempotencyRecord{})
+
+	// left in progress by a crashed process
+	db := DB()
+	stale := idempotencyRecord{
+		Key:         idempotencyKey("", "a"),
+		Fingerprint: "other",
+		CreatedAt:   time.Now().Add(-2 * time.Minute),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	if err := db.Create(&stale).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lockTimeout time.Duration
+		status      int
+	}{
+		{0, http.StatusUnprocessableEntity},
+		{time.Hour, http.StatusUnprocessableEntity},
+		{time.Minute, http.StatusCreated},
+	}
+	for _, test := range tests {
+		handler := Idempotency(IdempotencySettings{TTL: time.Hour, LockTimeout: test.lockTimeout}, nil)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			}),
+		)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+		r.Header.Set(idempotencyHeader, "a")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Fatalf("lock timeout %s: expected %d, got %d", test.lockTimeout, test.status, w.Code)
+		}
+	}
+}
+
+func TestIdempotencyTakenOverKey(t *testing.T) {
+	openTestDB(t, defaultDbName, &idempotencyRecord{})
+
+	// every request waits to be released, the first one outlives its lock
+	var (
+		started  = make(chan string)
+		releases = map[string]chan struct{}{"first": make(chan struct{}), "second": make(chan struct{})}
+		calls    atomic.Int32
+	)
+	handler := Idempotency(IdempotencySettings{TTL: time.Hour, LockTimeout: time.Nanosecond}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := "first"
+			if calls.Add(1) > 1 {
+				name = "second"
+			}
+			started <- name
+			<-releases[name]
+			w.Write([]byte(name))
+		}),
+	)
+
+	var wg sync.WaitGroup
+	send := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+			r.Header.Set(idempotencyHeader, "a")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+		<-started
+	}
+
+	send()
+	time.Sleep(time.Millisecond)
+	send()
+
+	// the first request finishes while the second one holds the lock
+	close(releases["first"])
+	time.Sleep(10 * time.Millisecond)
+	close(releases["second"])
+	wg.Wait()
+
+	var stored idempotencyRecord
+	if err := DB().First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != http.StatusOK || string(stored.Body) != "second" {
+		t.Fatalf("expected the response of the lock owner stored, got %d %s", stored.Status, stored.Body)
+	}
+}
+
+func TestRecordingResponseWriterFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := recordingResponseWriter{ResponseWriter: w}
+	if err := http.NewResponseController(&recorder).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Flushed || recorder.status != http.StatusOK {
+		t.Fatalf("expected the flushed 200 response, got %v %d", w.Flushed, recorder.status)
+	}
+	if _, ok := any(&recorder).(http.Hijacker); !ok {
+		t.Fatal("expected the writer to implement http.Hijacker")
+	}
+}
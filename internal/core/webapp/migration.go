@@ -0,0 +1,556 @@
+package webapp
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	migratepostgres "github.com/golang-migrate/migrate/v4/database/postgres"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultMigrationPath is the path of the migration files on disk
+	DefaultMigrationPath = "./db/migrations"
+	// AppMigrationSet holds the migrations of the app, applied after the sets of the modules
+	AppMigrationSet = "app"
+
+	defaultMigrationTable = "schema_migrations"
+
+	// goMigrationPrefix marks the body of a go migration read from the source
+	goMigrationPrefix = "-- webapp:go-migration "
+)
+
+var migrationTableRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+type (
+	// Migration is a go migration, e.g. a data migration needing the application
+	// logic. It is applied in a transaction, ordered with the sql migrations by its
+	// version and tracked in the same version table
+	Migration struct {
+		Version uint
+		Name    string
+		Up      func(tx *gorm.DB) error
+		Down    func(tx *gorm.DB) error
+	}
+
+	// MigrationSet is a migration sequence tracked in its own version table, e.g.
+	// the migrations shipped by a reusable module
+	MigrationSet struct {
+		// Name identifies the set, the versions are stored in schema_migrations_<name>
+		Name string
+		// FS holds the sql migrations, optionally in a directory per driver
+		FS         fs.FS
+		Migrations []Migration
+		// DependsOn are the sets applied before this one
+		DependsOn []string
+	}
+
+	// migrationSource adds the go migrations to the versions of the sql source
+	migrationSource struct {
+		source.Driver
+		migrations map[uint]Migration
+		versions   []uint
+	}
+
+	// migrationDatabase runs the go migrations read from the source, the sql
+	// migrations are run by the database driver
+	migrationDatabase struct {
+		database.Driver
+		db         *gorm.DB
+		migrations map[uint]Migration
+	}
+)
+
+// NewMigrate creates the migration of the set on the database using the
+// migration directory of its driver when exists, e.g. sqlite. The source is
+// closed along the migration
+func NewMigrate(settings DatabaseSettings, set MigrationSet) (*migrate.Migrate, source.Driver, error) {
+	driver, err := settings.DriverName()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sqlSource source.Driver
+	if set.FS != nil {
+		sqlSource, err = iofs.New(set.FS, MigrationFSDir(set.FS, driver))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open the migrations of %s: %w", set.Name, err)
+		}
+	}
+
+	src, err := newMigrationSource(sqlSource, set.Migrations)
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+
+	db, err := openMigrationDatabase(settings, driver, migrationTable(set.Name), src.migrations)
+	if err != nil {
+		src.Close()
+		return nil, nil, err
+	}
+
+	m, err := migrate.NewWithInstance("migrations", src, driver, db)
+	if err != nil {
+		src.Close()
+		db.Close()
+		return nil, nil, err
+	}
+	return m, src, nil
+}
+
+// AppMigrations returns the migration set of the app, the embedded migrations
+// are used unless the path is set
+func AppMigrations(path string, migrations ...Migration) MigrationSet {
+	set := MigrationSet{
+		Name:       AppMigrationSet,
+		FS:         MigrationFS,
+		Migrations: migrations,
+	}
+	if path != "" || MigrationFS == nil {
+		set.FS = os.DirFS(MigrationPath(path))
+	}
+	return set
+}
+
+// SortMigrationSets orders the sets by their dependencies, the app set is
+// always the last one
+func SortMigrationSets(sets []MigrationSet) ([]MigrationSet, error) {
+	var (
+		byName  = make(map[string]MigrationSet, len(sets))
+		byTable = make(map[string]string, len(sets))
+	)
+	for _, set := range sets {
+		if _, ok := byName[set.Name]; ok {
+			return nil, fmt.Errorf("duplicate migration set %s", set.Name)
+		}
+		byName[set.Name] = set
+
+		// the names are sanitized, e.g. a-b and a_b share the same table
+		table := migrationTable(set.Name)
+		if other, ok := byTable[table]; ok {
+			return nil, fmt.Errorf("migration sets %s and %s use the same version table %s", other, set.Name, table)
+		}
+		byTable[table] = set.Name
+	}
+
+	var (
+		sorted   []MigrationSet
+		visited  = make(map[string]bool)
+		visiting = make(map[string]bool)
+		visit    func(name string, dependent string) error
+	)
+	visit = func(name string, dependent string) error {
+		set, ok := byName[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("migration set %s depends on unknown set %s", dependent, name)
+		case name == AppMigrationSet && dependent != "":
+			return fmt.Errorf("migration set %s can't depend on the app migrations", dependent)
+		case visited[name]:
+			return nil
+		case visiting[name]:
+			return fmt.Errorf("migration sets %s and %s depend on each other", dependent, name)
+		}
+
+		visiting[name] = true
+		for _, dependency := range set.DependsOn {
+			if err := visit(dependency, name); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+
+		sorted = append(sorted, set)
+		return nil
+	}
+
+	// keep the registration order of the independent sets
+	for _, set := range sets {
+		if set.Name == AppMigrationSet {
+			continue
+		}
+		if err := visit(set.Name, ""); err != nil {
+			return nil, err
+		}
+	}
+	if app, ok := byName[AppMigrationSet]; ok {
+		sorted = append(sorted, app)
+	}
+	return sorted, nil
+}
+
+// migrationTable returns the version table of the set
+func migrationTable(name string) string {
+	if name == AppMigrationSet {
+		return defaultMigrationTable
+	}
+	return defaultMigrationTable + "_" + migrationTableRegex.ReplaceAllString(strings.ToLower(name), "_")
+}
+
+// openMigrationDatabase opens the database driver of the migration, its
+// connections are closed along the migration
+func openMigrationDatabase(settings DatabaseSettings, driver string, table string, migrations map[uint]Migration) (database.Driver, error) {
+	// allow multiple statements in a migration file
+	if driver == DriverMySQL {
+		settings.Uri = withDSNParam(settings.Uri, "multiStatements", "true")
+	}
+
+	// the driver keeps a connection while the go migrations run on another
+	settings.MaxOpenConns = 2
+	settings.MaxIdleConns = 2
+	settings.LazyConnect = false
+	db, sqlDb, err := openGorm(settings, "migration")
+	if err != nil {
+		return nil, err
+	}
+
+	var instance database.Driver
+	switch driver {
+	case DriverMySQL:
+		instance, err = migratemysql.WithInstance(sqlDb, &migratemysql.Config{MigrationsTable: table})
+	case DriverSQLite:
+		instance, err = migratesqlite.WithInstance(sqlDb, &migratesqlite.Config{MigrationsTable: table})
+	default:
+		instance, err = migratepostgres.WithInstance(sqlDb, &migratepostgres.Config{MigrationsTable: table})
+	}
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+
+	return &migrationDatabase{Driver: instance, db: db, migrations: migrations}, nil
+}
+
+// newMigrationSource merges the go migrations with the sql source, the source is
+// optional for sets having only go migrations
+func newMigrationSource(src source.Driver, migrations []Migration) (*migrationSource, error) {
+	s := migrationSource{
+		Driver:     src,
+		migrations: make(map[uint]Migration, len(migrations)),
+	}
+
+	sqlVersions := make(map[uint]bool)
+	if src != nil {
+		version, err := src.First()
+		for err == nil {
+			s.versions = append(s.versions, version)
+			sqlVersions[version] = true
+			version, err = src.Next(version)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return &s, err
+		}
+	}
+
+	for _, migration := range migrations {
+		if _, ok := s.migrations[migration.Version]; ok {
+			return &s, fmt.Errorf("duplicate go migration version %d", migration.Version)
+		}
+		if sqlVersions[migration.Version] {
+			return &s, fmt.Errorf("go migration version %d is already used by a sql migration", migration.Version)
+		}
+
+		s.migrations[migration.Version] = migration
+		s.versions = append(s.versions, migration.Version)
+	}
+	slices.Sort(s.versions)
+	return &s, nil
+}
+
+// First implements source.Driver
+func (s *migrationSource) First() (uint, error) {
+	if len(s.versions) == 0 {
+		return 0, &fs.PathError{Op: "first", Path: "migrations", Err: fs.ErrNotExist}
+	}
+	return s.versions[0], nil
+}
+
+// Prev implements source.Driver
+func (s *migrationSource) Prev(version uint) (uint, error) {
+	i, ok := slices.BinarySearch(s.versions, version)
+	if !ok || i == 0 {
+		return 0, &fs.PathError{Op: "prev for version " + strconv.FormatUint(uint64(version), 10), Path: "migrations", Err: fs.ErrNotExist}
+	}
+	return s.versions[i-1], nil
+}
+
+// Next implements source.Driver
+func (s *migrationSource) Next(version uint) (uint, error) {
+	i, ok := slices.BinarySearch(s.versions, version)
+	if !ok || i == len(s.versions)-1 {
+		return 0, &fs.PathError{Op: "next for version " + strconv.FormatUint(uint64(version), 10), Path: "migrations", Err: fs.ErrNotExist}
+	}
+	return s.versions[i+1], nil
+}
+
+// ReadUp implements source.Driver
+func (s *migrationSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if migration, ok := s.migrations[version]; ok {
+		return s.readGo(migration, "up", migration.Up)
+	}
+	if s.Driver == nil {
+		return nil, "", &fs.PathError{Op: "read up", Path: "migrations", Err: fs.ErrNotExist}
+	}
+	return s.Driver.ReadUp(version)
+}
+
+// ReadDown implements source.Driver
+func (s *migrationSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if migration, ok := s.migrations[version]; ok {
+		return s.readGo(migration, "down", migration.Down)
+	}
+	if s.Driver == nil {
+		return nil, "", &fs.PathError{Op: "read down", Path: "migrations", Err: fs.ErrNotExist}
+	}
+	return s.Driver.ReadDown(version)
+}
+
+// Close implements source.Driver
+func (s *migrationSource) Close() error {
+	if s.Driver == nil {
+		return nil
+	}
+	return s.Driver.Close()
+}
+
+// readGo returns the body marking the go migration, only the version is
+// migrated when the function is missing
+func (s *migrationSource) readGo(migration Migration, direction string, f func(tx *gorm.DB) error) (io.ReadCloser, string, error) {
+	if f == nil {
+		return nil, "", &fs.PathError{Op: "read " + direction, Path: migration.Name, Err: fs.ErrNotExist}
+	}
+
+	body := fmt.Sprintf("%s%d %s", goMigrationPrefix, migration.Version, direction)
+	return io.NopCloser(strings.NewReader(body)), migration.Name, nil
+}
+
+// Run implements database.Driver
+func (d *migrationDatabase) Run(migration io.Reader) error {
+	body, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	header, ok := strings.CutPrefix(string(body), goMigrationPrefix)
+	if !ok {
+		return d.Driver.Run(bytes.NewReader(body))
+	}
+
+	var (
+		version   uint
+		direction string
+	)
+	if _, err := fmt.Sscanf(header, "%d %s", &version, &direction); err != nil {
+		return fmt.Errorf("invalid go migration %q: %w", header, err)
+	}
+
+	f := d.migrations[version].Up
+	if direction == "down" {
+		f = d.migrations[version].Down
+	}
+	if f == nil {
+		return fmt.Errorf("go migration %d has no %s function", version, direction)
+	}
+
+	return d.db.Transaction(f)
+}
+
+// MigrationPath returns the path of the migration files on disk
+func MigrationPath(path string) string {
+	if path == "" {
+		return DefaultMigrationPath
+	}
+	return path
+}
+
+// autoMigrate applies the pending migrations of the sets on the databases, the
+// migration is serialized across the app instances by a database lock
+func autoMigrate(ctx context.Context, settings MigrationSettings, connections map[string]DatabaseSettings, sets []MigrationSet) error {
+	for _, name := range settings.Databases {
+		dbSettings, ok := connections[name]
+		if !ok {
+			return fmt.Errorf("database %s is not configured", name)
+		}
+
+		if err := migrateLocked(ctx, name, dbSettings, sets); err != nil {
+			return fmt.Errorf("failed to migrate database %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func migrateLocked(ctx context.Context, name string, settings DatabaseSettings, sets []MigrationSet) error {
+	db, err := GetDB(name)
+	if err != nil {
+		return err
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	driver, err := settings.DriverName()
+	if err != nil {
+		return err
+	}
+
+	// the lock is held by the session, keep using the same connection
+	conn, err := sqlDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Info("acquiring the migration lock", log.WithField("name", name))
+	unlock, err := lockMigration(ctx, conn, driver, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, set := range sets {
+		if err := migrateSet(ctx, name, settings, set); err != nil {
+			return fmt.Errorf("migration set %s: %w", set.Name, err)
+		}
+	}
+	return nil
+}
+
+func migrateSet(ctx context.Context, name string, settings DatabaseSettings, set MigrationSet) error {
+	m, src, err := NewMigrate(settings, set)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := checkMigrationVersion(m, src); err != nil {
+		return err
+	}
+
+	// stop applying the pending migrations once the start is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.GracefulStop <- true
+		case <-done:
+		}
+	}()
+
+	err = m.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	// the stopped migrations return no error, leaving the rest pending
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Info("database is up to date", log.WithField("name", name), log.WithField("set", set.Name))
+		return nil
+	}
+
+	version, _, _ := m.Version()
+	log.Info("database migrated",
+		log.WithField("name", name),
+		log.WithField("set", set.Name),
+		log.WithField("version", version),
+	)
+	return nil
+}
+
+// checkMigrationVersion refuses to migrate a dirty database or one migrated by
+// a newer binary, i.e. ahead of the migrations
+func checkMigrationVersion(m *migrate.Migrate, src source.Driver) error {
+	current, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("database is dirty at version %d, fix it manually then run migration force", current)
+	}
+
+	latest, err := src.First()
+	for err == nil {
+		var next uint
+		if next, err = src.Next(latest); err == nil {
+			latest = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if current > latest {
+		return fmt.Errorf("database version %d is ahead of the latest migration %d", current, latest)
+	}
+	return nil
+}
+
+// lockMigration acquires the lock of the database migration, waits while it is
+// held by another instance. The sqlite databases are not shared so they are not locked
+func lockMigration(ctx context.Context, conn *sql.Conn, driver string, name string) (func(), error) {
+	key := "webapp:migration:" + name
+	unlock := func(query string, args ...any) func() {
+		return func() {
+			// the context may be cancelled already
+			if _, err := conn.ExecContext(context.Background(), query, args...); err != nil {
+				log.Error("failed to release the migration lock",
+					log.WithField("name", name),
+					log.WithError(err),
+				)
+			}
+		}
+	}
+
+	switch driver {
+	case DriverPostgres:
+		// advisory locks are identified by a number
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		id := int64(hash.Sum64())
+
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", id); err != nil {
+			return nil, err
+		}
+		return unlock("SELECT pg_advisory_unlock($1)", id), nil
+	case DriverMySQL:
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", key).Scan(&acquired); err != nil {
+			return nil, err
+		}
+		if acquired.Int64 != 1 {
+			return nil, errors.New("failed to acquire the migration lock")
+		}
+		return unlock("SELECT RELEASE_LOCK(?)", key), nil
+	default:
+		return func() {}, nil
+	}
+}
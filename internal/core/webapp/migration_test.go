@@ -0,0 +1,212 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"gorm.io/gorm"
+)
+
+func TestSortMigrationSets(t *testing.T) {
+	tests := []struct {
+		name  string
+		sets  []MigrationSet
+		order []string
+		err   string
+	}{
+		{"dependency order", []MigrationSet{
+			{Name: AppMigrationSet},
+			{Name: "orders", DependsOn: []string{"users"}},
+			{Name: "users"},
+		}, []string{"users", "orders", AppMigrationSet}, ""},
+		{"duplicate name", []MigrationSet{{Name: "users"}, {Name: "users"}}, nil, "duplicate migration set users"},
+		{"same table", []MigrationSet{{Name: "user-roles"}, {Name: "user_roles"}}, nil, "use the same version table schema_migrations_user_roles"},
+		{"unknown dependency", []MigrationSet{{Name: "orders", DependsOn: []string{"users"}}}, nil, "unknown set users"},
+		{"cycle", []MigrationSet{
+			{Name: "users", DependsOn: []string{"orders"}},
+			{Name: "orders", DependsOn: []string{"users"}},
+		}, nil, "depend on each other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sorted, err := SortMigrationSets(test.sets)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := make([]string, len(sorted))
+			for i, set := range sorted {
+				order[i] = set.Name
+			}
+			if strings.Join(order, ",") != strings.Join(test.order, ",") {
+				t.Fatalf("expected %v, got %v", test.order, order)
+			}
+		})
+	}
+}
+
+func TestNewMigrationSource(t *testing.T) {
+	sqlSource := func(versions ...uint) source.Driver {
+		fsys := fstest.MapFS{}
+		for _, version := range versions {
+			fsys[fmt.Sprintf("%d_sql.up.sql", version)] = &fstest.MapFile{}
+		}
+		src, err := iofs.New(fsys, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+	goMigrations := func(versions ...uint) []Migration {
+		var migrations []Migration
+		for _, version := range versions {
+			migrations = append(migrations, Migration{Version: version, Name: "go"})
+		}
+		return migrations
+	}
+
+	tests := []struct {
+		name       string
+		src        source.Driver
+		migrations []Migration
+		versions   []uint
+		err        string
+	}{
+		{"merged in order", sqlSource(30, 10), goMigrations(20, 5), []uint{5, 10, 20, 30}, ""},
+		{"go migrations only", nil, goMigrations(2, 1), []uint{1, 2}, ""},
+		{"sql migrations only", sqlSource(2, 1), nil, []uint{1, 2}, ""},
+		{"duplicate go migration", nil, goMigrations(1, 2, 1), nil, "duplicate go migration version 1"},
+		{"sql version after smaller go versions", sqlSource(10, 20), goMigrations(1, 2, 3, 20), nil, "version 20 is already used by a sql migration"},
+		{"go migration before a sql one", sqlSource(10, 20), goMigrations(10), nil, "version 10 is already used by a sql migration"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, err := newMigrationSource(test.src, test.migrations)
+			defer src.Close()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// walk the versions the way the migration does
+			var versions []uint
+			version, err := src.First()
+			for err == nil {
+				versions = append(versions, version)
+				version, err = src.Next(version)
+			}
+			if !slices.Equal(versions, test.versions) {
+				t.Fatalf("expected versions %v, got %v", test.versions, versions)
+			}
+		})
+	}
+}
+
+func TestGoMigrations(t *testing.T) {
+	type goItem struct {
+		ID   int64
+		Name string
+	}
+
+	set := MigrationSet{
+		Name: "items",
+		FS: fstest.MapFS{
+			"1_create_go_items.up.sql":   {Data: []byte("CREATE TABLE go_items (id INTEGER PRIMARY KEY, name TEXT);")},
+			"1_create_go_items.down.sql": {Data: []byte("DROP TABLE go_items;")},
+		},
+		Migrations: []Migration{
+			{
+				Version: 2,
+				Name:    "seed_go_items",
+				Up: func(tx *gorm.DB) error {
+					return tx.Create(&goItem{ID: 1, Name: "seeded"}).Error
+				},
+				Down: func(tx *gorm.DB) error {
+					return tx.Where("id = ?", 1).Delete(&goItem{}).Error
+				},
+			},
+			{
+				// only the version is reverted without a down function
+				Version: 3,
+				Name:    "rename_go_items",
+				Up: func(tx *gorm.DB) error {
+					return tx.Model(&goItem{}).Where("id = ?", 1).Update("name", "renamed").Error
+				},
+			},
+		},
+	}
+
+	settings := DatabaseSettings{Uri: "sqlite://" + filepath.Join(t.TempDir(), "app.db")}
+	m, _, err := NewMigrate(settings, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := OpenDB(settings, "go_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB("go_migrations")
+	items := func() []goItem {
+		var items []goItem
+		if err := DB("go_migrations").Find(&items).Error; err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if items := items(); len(items) != 1 || items[0].Name != "renamed" {
+		t.Fatalf("expected the renamed item, got %+v", items)
+	}
+
+	steps := []struct {
+		version uint
+		items   int
+	}{
+		{2, 1},
+		{1, 0},
+	}
+	for _, step := range steps {
+		if err := m.Steps(-1); err != nil {
+			t.Fatal(err)
+		}
+		if version, _, _ := m.Version(); version != step.version {
+			t.Fatalf("expected version %d, got %d", step.version, version)
+		}
+		if items := items(); len(items) != step.items {
+			t.Fatalf("version %d: expected %d items, got %+v", step.version, step.items, items)
+		}
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Version(); !errors.Is(err, migrate.ErrNilVersion) {
+		t.Fatalf("expected no version after migrating down, got %v", err)
+	}
+	if DB("go_migrations").Migrator().HasTable("go_items") {
+		t.Fatal("expected the table dropped")
+	}
+}
@@ -13,24 +13,79 @@ type (
 		Close() error
 	}
 
+	// NamedModule names the module in logs and route conflicts
+	NamedModule interface {
+		Name() string
+	}
+
 	APIService interface {
 		APIRoute(router chi.Router)
 	}
 
+	// APIVersionedService registers routes for specific api versions
+	APIVersionedService interface {
+		APIVersionRoute(versions *APIVersions)
+	}
+
+	// RootService registers routes on the root router, e.g. /.well-known or /webhooks
+	RootService interface {
+		RootRoute(router chi.Router)
+	}
+
+	// PrefixedService mounts the api routes of the module under its prefix
+	PrefixedService interface {
+		RoutePrefix() string
+	}
+
+	// MiddlewareService applies the middlewares only to the routes of the module
+	MiddlewareService interface {
+		Middlewares() []Middleware
+	}
+
 	CLI interface {
 		Command(cmd *cobra.Command)
 	}
 
+	// MigrationService registers go migrations applied along the sql migrations
+	MigrationService interface {
+		Migrations() []Migration
+	}
+
+	// MigrationSetService ships migrations tracked independently of the app
+	// migrations, e.g. by a reusable module
+	MigrationSetService interface {
+		MigrationSet() MigrationSet
+	}
+
+	// ScopedModule is only initialized for the commands of its scopes, e.g. ScopeServer
+	ScopedModule interface {
+		Scopes() []string
+	}
+
 	ModuleOption func(*module)
 
 	module struct {
+		name        string
 		initFunc    func(ctx context.Context) error
 		closeFunc   func() error
 		serviceFunc func(router chi.Router)
 		cliFunc     func(cmd *cobra.Command)
+		rootFunc    func(router chi.Router)
+		versions    APIVersions
+		prefix      string
+		middlewares []Middleware
+		scopes      []string
+		migrations  []Migration
+		set         MigrationSet
 	}
 )
 
+func WithName(name string) ModuleOption {
+	return func(m *module) {
+		m.name = name
+	}
+}
+
 func WithInit(f func(ctx context.Context) error) ModuleOption {
 	return func(m *module) {
 		m.initFunc = f
@@ -49,12 +104,56 @@ func WithService(f func(router chi.Router)) ModuleOption {
 	}
 }
 
+func WithAPIVersion(version string, f func(router chi.Router), opts ...APIVersionOption) ModuleOption {
+	return func(m *module) {
+		m.versions.Route(version, f, opts...)
+	}
+}
+
+func WithRootRoute(f func(router chi.Router)) ModuleOption {
+	return func(m *module) {
+		m.rootFunc = f
+	}
+}
+
+func WithRoutePrefix(prefix string) ModuleOption {
+	return func(m *module) {
+		m.prefix = prefix
+	}
+}
+
+func WithMiddlewares(middlewares ...Middleware) ModuleOption {
+	return func(m *module) {
+		m.middlewares = append(m.middlewares, middlewares...)
+	}
+}
+
 func WithCLI(f func(cmd *cobra.Command)) ModuleOption {
 	return func(m *module) {
 		m.cliFunc = f
 	}
 }
 
+func WithScopes(scopes ...string) ModuleOption {
+	return func(m *module) {
+		m.scopes = append(m.scopes, scopes...)
+	}
+}
+
+func WithMigrations(migrations ...Migration) ModuleOption {
+	return func(m *module) {
+		m.migrations = append(m.migrations, migrations...)
+	}
+}
+
+// WithMigrationSet ships the migrations of the module in their own set, the
+// set is named after the module unless named
+func WithMigrationSet(set MigrationSet) ModuleOption {
+	return func(m *module) {
+		m.set = set
+	}
+}
+
 func NewModule(opts ...ModuleOption) Module {
 	m := &module{}
 	for _, opt := range opts {
@@ -63,6 +162,10 @@ func NewModule(opts ...ModuleOption) Module {
 	return m
 }
 
+func (m *module) Name() string {
+	return m.name
+}
+
 func (m *module) Init(ctx context.Context) error {
 	if m.initFunc != nil {
 		return m.initFunc(ctx)
@@ -77,13 +180,56 @@ func (m *module) Close() error {
 	return nil
 }
 
-func (m *module) Route(router chi.Router) {
+func (m *module) APIRoute(router chi.Router) {
 	if m.serviceFunc != nil {
 		m.serviceFunc(router)
 	}
 }
+
+func (m *module) RootRoute(router chi.Router) {
+	if m.rootFunc != nil {
+		m.rootFunc(router)
+	}
+}
+
+func (m *module) RoutePrefix() string {
+	return m.prefix
+}
+
+func (m *module) Middlewares() []Middleware {
+	return m.middlewares
+}
+
+func (m *module) APIVersionRoute(versions *APIVersions) {
+	versions.routes = append(versions.routes, m.versions.routes...)
+}
+
 func (m *module) Command(cmd *cobra.Command) {
 	if m.cliFunc != nil {
 		m.cliFunc(cmd)
 	}
 }
+
+// hasCapability reports whether the module routes, adds commands or manages a
+// resource through its lifecycle, e.g. the database module
+func (m *module) hasCapability() bool {
+	return m.serviceFunc != nil || m.rootFunc != nil || m.cliFunc != nil || len(m.versions.routes) > 0 ||
+		m.initFunc != nil || m.closeFunc != nil || len(m.migrations) > 0 ||
+		m.set.FS != nil || len(m.set.Migrations) > 0
+}
+
+func (m *module) Migrations() []Migration {
+	return m.migrations
+}
+
+func (m *module) MigrationSet() MigrationSet {
+	return m.set
+}
+
+func (m *module) Scopes() []string {
+	return m.scopes
+}
+
+func (m *module) commandOnly() bool {
+	return m.cliFunc != nil && m.serviceFunc == nil && m.rootFunc == nil && len(m.versions.routes) == 0
+}
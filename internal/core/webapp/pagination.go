@@ -0,0 +1,513 @@
+package webapp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultTieBreaker = "id"
+
+var (
+	// holds the pagination settings, configured when the app runs
+	paginationSettings = PaginationSettings{DefaultLimit: 20, MaxLimit: 100}
+	cursorSecret       []byte
+	cursorSecretMu     sync.Mutex
+
+	ErrInvalidLimit  = errors.New("invalid limit")
+	ErrInvalidOffset = errors.New("invalid offset")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+type (
+	// PaginationOptions configures how a list request is paginated
+	PaginationOptions struct {
+		// SortFields whitelists the sortable fields, keyed by the query name with the column as value
+		SortFields map[string]string
+		// DefaultSort is used when no sort is requested, e.g. []string{"-created_at"}
+		DefaultSort []string
+		// TieBreaker is an unique column appended to the sort to ensure stable ordering, default to id
+		TieBreaker string
+		// Keyset enables cursor based pagination instead of offset
+		Keyset bool
+		// DefaultLimit and MaxLimit override the limit settings when not zero
+		DefaultLimit int
+		MaxLimit     int
+	}
+
+	// SortField is a single whitelisted sort column
+	SortField struct {
+		Name   string
+		Column string
+		Desc   bool
+	}
+
+	// Pagination holds the parsed pagination request
+	Pagination struct {
+		Limit  int
+		Offset int
+		Sort   []SortField
+
+		keyset bool
+		after  []interface{}
+	}
+
+	// Page is the standard paginated response envelope
+	Page[T any] struct {
+		Data []T      `json:"data"`
+		Meta PageMeta `json:"meta"`
+
+		pagination *Pagination
+	}
+
+	PageMeta struct {
+		Total      int64  `json:"total"`
+		Limit      int    `json:"limit"`
+		Offset     *int   `json:"offset,omitempty"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		HasMore    bool   `json:"has_more"`
+	}
+
+	cursorPayload struct {
+		Sort   string        `json:"s"`
+		Values []interface{} `json:"v"`
+	}
+)
+
+// ParsePagination parses limit, offset, cursor and sort query parameters of the request
+func ParsePagination(r *http.Request, opts PaginationOptions) (*Pagination, error) {
+	var (
+		query    = r.URL.Query()
+		maxLimit = opts.MaxLimit
+		p        = Pagination{
+			Limit:  opts.DefaultLimit,
+			keyset: opts.Keyset,
+		}
+	)
+
+	// fallback to the global settings
+	if p.Limit <= 0 {
+		p.Limit = paginationSettings.DefaultLimit
+	}
+	if maxLimit <= 0 {
+		maxLimit = paginationSettings.MaxLimit
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return nil, ErrInvalidLimit
+		}
+		p.Limit = limit
+	}
+	if maxLimit > 0 && p.Limit > maxLimit {
+		p.Limit = maxLimit
+	}
+
+	sort, err := parseSort(query.Get("sort"), opts)
+	if err != nil {
+		return nil, err
+	}
+	p.Sort = sort
+
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 || p.keyset {
+			return nil, ErrInvalidOffset
+		}
+		p.Offset = offset
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		if !p.keyset {
+			return nil, ErrInvalidCursor
+		}
+
+		payload, err := decodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		// the cursor only valid for the same sort
+		if payload.Sort != p.sortSignature() || len(payload.Values) != len(p.Sort) {
+			return nil, ErrInvalidCursor
+		}
+		p.after = payload.Values
+	}
+
+	return &p, nil
+}
+
+// Scope returns a gorm scope that applies the ordering, limit and offset or cursor condition
+func (p *Pagination) Scope() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, s := range p.Sort {
+			// the cursor condition expects the nulls last whatever the driver default is
+			if p.keyset {
+				db = db.Order(clause.OrderByColumn{Column: clause.Column{
+					Name: db.Statement.Quote(clause.Column{Name: s.Column}) + " IS NULL",
+					Raw:  true,
+				}})
+			}
+			db = db.Order(clause.OrderByColumn{
+				Column: clause.Column{Name: s.Column},
+				Desc:   s.Desc,
+			})
+		}
+
+		if p.keyset && len(p.after) > 0 {
+			db = db.Where(p.keysetCondition())
+		}
+
+		if !p.keyset && p.Offset > 0 {
+			db = db.Offset(p.Offset)
+		}
+
+		// fetch one more row to know whether there are more rows
+		return db.Limit(p.Limit + 1)
+	}
+}
+
+// keysetCondition builds (a > ?) OR (a = ? AND b > ?) ... that supports mixed
+// directions. The nulls are sorted last, so a null is after every value and
+// nothing is after a null but the next columns
+func (p *Pagination) keysetCondition() clause.Expression {
+	exprs := make([]clause.Expression, 0, len(p.Sort))
+	for i, s := range p.Sort {
+		// eq with a nil value is built as IS NULL
+		conds := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			conds = append(conds, clause.Eq{
+				Column: clause.Column{Name: p.Sort[j].Column},
+				Value:  p.after[j],
+			})
+		}
+
+		if p.after[i] == nil {
+			continue
+		}
+
+		var (
+			column = clause.Column{Name: s.Column}
+			after  clause.Expression
+		)
+		if s.Desc {
+			after = clause.Lt{Column: column, Value: p.after[i]}
+		} else {
+			after = clause.Gt{Column: column, Value: p.after[i]}
+		}
+		conds = append(conds, clause.Or(after, clause.Eq{Column: column, Value: nil}))
+		exprs = append(exprs, clause.And(conds...))
+	}
+
+	// the cursor points to the last row
+	if len(exprs) == 0 {
+		return clause.Expr{SQL: "1 = 0"}
+	}
+	return clause.Or(exprs...)
+}
+
+func (p *Pagination) sortSignature() string {
+	parts := make([]string, len(p.Sort))
+	for i, s := range p.Sort {
+		parts[i] = s.Column
+		if s.Desc {
+			parts[i] = "-" + s.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursorFor creates a signed cursor that points after the item
+func (p *Pagination) cursorFor(db *gorm.DB, item interface{}) (string, error) {
+	stmt := gorm.Statement{DB: db}
+	if err := stmt.Parse(item); err != nil {
+		return "", err
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(item))
+	values := make([]interface{}, len(p.Sort))
+	for i, s := range p.Sort {
+		// strip the table prefix if any
+		column := s.Column
+		if idx := strings.LastIndex(column, "."); idx >= 0 {
+			column = column[idx+1:]
+		}
+
+		field := stmt.Schema.LookUpField(column)
+		if field == nil {
+			return "", fmt.Errorf("sort column %s not found in %s", s.Column, stmt.Schema.Name)
+		}
+		values[i], _ = field.ValueOf(db.Statement.Context, value)
+	}
+
+	return encodeCursor(cursorPayload{
+		Sort:   p.sortSignature(),
+		Values: values,
+	})
+}
+
+// FindPage counts the total rows and finds the requested page of T
+func FindPage[T any](db *gorm.DB, p *Pagination) (*Page[T], error) {
+	var (
+		total int64
+		items []T
+		tx    = db.Session(&gorm.Session{})
+	)
+
+	if err := tx.Model(new(T)).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Scopes(p.Scope()).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	page := Page[T]{
+		Data: items,
+		Meta: PageMeta{
+			Total: total,
+			Limit: p.Limit,
+		},
+		pagination: p,
+	}
+
+	// trim the extra row used for lookahead
+	if len(items) > p.Limit {
+		page.Data = items[:p.Limit]
+		page.Meta.HasMore = true
+	}
+
+	if !p.keyset {
+		offset := p.Offset
+		page.Meta.Offset = &offset
+		return &page, nil
+	}
+
+	if page.Meta.HasMore {
+		cursor, err := p.cursorFor(tx, &page.Data[len(page.Data)-1])
+		if err != nil {
+			return nil, err
+		}
+		page.Meta.NextCursor = cursor
+	}
+
+	return &page, nil
+}
+
+// WritePage writes the page as JSON along with its Link header
+func WritePage[T any](w http.ResponseWriter, r *http.Request, page *Page[T]) {
+	if links := page.links(r.URL); len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	WriteJSON(w, page)
+}
+
+func (page *Page[T]) links(u *url.URL) []string {
+	var (
+		links []string
+		p     = page.pagination
+	)
+
+	if p == nil {
+		return nil
+	}
+
+	link := func(rel string, set map[string]string) {
+		query := u.Query()
+		query.Del("offset")
+		query.Del("cursor")
+		for k, v := range set {
+			query.Set(k, v)
+		}
+
+		target := url.URL{Path: u.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, target.String(), rel))
+	}
+
+	if p.keyset {
+		link("first", nil)
+		if page.Meta.NextCursor != "" {
+			link("next", map[string]string{"cursor": page.Meta.NextCursor})
+		}
+		return links
+	}
+
+	limit := int64(p.Limit)
+	link("first", nil)
+	if p.Offset > 0 {
+		prev := max(int64(p.Offset)-limit, 0)
+		link("prev", map[string]string{"offset": strconv.FormatInt(prev, 10)})
+	}
+	if page.Meta.HasMore {
+		link("next", map[string]string{"offset": strconv.FormatInt(int64(p.Offset)+limit, 10)})
+	}
+	if page.Meta.Total > 0 {
+		last := ((page.Meta.Total - 1) / limit) * limit
+		link("last", map[string]string{"offset": strconv.FormatInt(last, 10)})
+	}
+
+	return links
+}
+
+func parseSort(raw string, opts PaginationOptions) ([]SortField, error) {
+	var (
+		sort    []SortField
+		columns = make(map[string]bool)
+		fields  = opts.DefaultSort
+	)
+
+	if raw != "" {
+		fields = strings.Split(raw, ",")
+	}
+
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		name := strings.TrimLeft(field, "+-")
+
+		column, ok := opts.SortFields[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not sortable", ErrInvalidSort, name)
+		}
+
+		if columns[column] {
+			continue
+		}
+		columns[column] = true
+		sort = append(sort, SortField{Name: name, Column: column, Desc: desc})
+	}
+
+	// ensure stable ordering by appending the tie breaker
+	tieBreaker := opts.TieBreaker
+	if tieBreaker == "" {
+		tieBreaker = defaultTieBreaker
+	}
+	if !columns[tieBreaker] {
+		sort = append(sort, SortField{Name: tieBreaker, Column: tieBreaker})
+	}
+
+	return sort, nil
+}
+
+func encodeCursor(payload cursorPayload) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signCursor(data)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(data) + "." + encoding.EncodeToString(signature), nil
+}
+
+func decodeCursor(raw string) (*cursorPayload, error) {
+	var (
+		payload  cursorPayload
+		encoding = base64.RawURLEncoding
+	)
+
+	data, signature, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	decoded, err := encoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	expected, err := signCursor(decoded)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedSignature, err := encoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, expected) {
+		return nil, ErrInvalidCursor
+	}
+
+	// use number to keep integer values intact
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, v := range payload.Values {
+		number, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+
+		if n, err := number.Int64(); err == nil {
+			payload.Values[i] = n
+		} else if f, err := number.Float64(); err == nil {
+			payload.Values[i] = f
+		}
+	}
+
+	return &payload, nil
+}
+
+func signCursor(data []byte) ([]byte, error) {
+	secret, err := cursorKey()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// cursorKey returns the cursor secret, a random secret is generated on the
+// first use when not set, i.e. only by the commands using cursors
+func cursorKey() ([]byte, error) {
+	cursorSecretMu.Lock()
+	defer cursorSecretMu.Unlock()
+
+	if cursorSecret != nil {
+		return cursorSecret, nil
+	}
+
+	// cursors won't survive restarts
+	log.Warning("pagination cursor secret is not set, using a random secret")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate the cursor secret: %w", err)
+	}
+	cursorSecret = secret
+	return cursorSecret, nil
+}
+
+func initializePagination(settings PaginationSettings) {
+	cursorSecretMu.Lock()
+	defer cursorSecretMu.Unlock()
+
+	paginationSettings = settings
+	cursorSecret = nil
+	if settings.CursorSecret != "" {
+		cursorSecret = []byte(settings.CursorSecret)
+	}
+}
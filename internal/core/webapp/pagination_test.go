@@ -0,0 +1,238 @@
+package webapp
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type paginatedItem struct {
+	ID    int64
+	Name  *string
+	Score int
+}
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Dialector{DriverName: sqliteDriverName, DSN: ":memory:"}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCursorEncoding(t *testing.T) {
+	initializePagination(PaginationSettings{CursorSecret: "secret"})
+
+	payload := cursorPayload{Sort: "-score,id", Values: []interface{}{int64(10), 1.5, "a", nil}}
+	cursor, err := encodeCursor(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decoded, payload) {
+		t.Fatalf("decoded %#v, expected %#v", *decoded, payload)
+	}
+
+	data, signature, _ := strings.Cut(cursor, ".")
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"missing signature", data},
+		{"invalid base64", "!." + signature},
+		{"tampered payload", strings.ToUpper(data[:1]) + strings.ToLower(data[1:]) + "." + signature},
+		{"tampered signature", data + "." + signature[1:] + "A"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeCursor(test.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+
+	// cursors signed with another secret are rejected
+	initializePagination(PaginationSettings{CursorSecret: "other"})
+	if _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestParsePaginationCursor(t *testing.T) {
+	initializePagination(PaginationSettings{CursorSecret: "secret", DefaultLimit: 20, MaxLimit: 100})
+	opts := PaginationOptions{
+		SortFields: map[string]string{"score": "score", "name": "name"},
+		Keyset:     true,
+	}
+
+	cursor, err := encodeCursor(cursorPayload{Sort: "-score,id", Values: []interface{}{5, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		err   error
+	}{
+		{"matching sort", "sort=-score&cursor=" + cursor, nil},
+		{"other sort", "sort=score&cursor=" + cursor, ErrInvalidCursor},
+		{"offset with keyset", "offset=10", ErrInvalidOffset},
+		{"invalid limit", "limit=0", ErrInvalidLimit},
+		{"unknown sort", "sort=password", ErrInvalidSort},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/items?"+test.query, nil)
+			p, err := ParsePagination(r, opts)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err == nil && !reflect.DeepEqual(p.after, []interface{}{int64(5), int64(2)}) {
+				t.Fatalf("unexpected cursor values %v", p.after)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	db := dryRunDB(t)
+
+	tests := []struct {
+		name  string
+		sort  []SortField
+		after []interface{}
+		sql   string
+		vars  []interface{}
+	}{
+		{
+			name:  "single column",
+			sort:  []SortField{{Column: "id"}},
+			after: []interface{}{10},
+			sql:   "WHERE (`id` > ? OR `id` IS NULL) ORDER BY `id` IS NULL,`id` LIMIT 3",
+			vars:  []interface{}{10},
+		},
+		{
+			name:  "mixed directions",
+			sort:  []SortField{{Column: "score", Desc: true}, {Column: "id"}},
+			after: []interface{}{5, 10},
+			sql: "WHERE ((`score` < ? OR `score` IS NULL) OR (`score` = ? AND (`id` > ? OR `id` IS NULL))) " +
+				"ORDER BY `score` IS NULL,`score` DESC,`id` IS NULL,`id` LIMIT 3",
+			vars: []interface{}{5, 5, 10},
+		},
+		{
+			name:  "null value",
+			sort:  []SortField{{Column: "name"}, {Column: "id"}},
+			after: []interface{}{nil, 10},
+			sql:   "WHERE (`name` IS NULL AND (`id` > ? OR `id` IS NULL)) ORDER BY `name` IS NULL,`name`,`id` IS NULL,`id` LIMIT 3",
+			vars:  []interface{}{10},
+		},
+		{
+			name:  "last row",
+			sort:  []SortField{{Column: "name"}},
+			after: []interface{}{nil},
+			sql:   "WHERE 1 = 0 ORDER BY `name` IS NULL,`name` LIMIT 3",
+			vars:  []interface{}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := Pagination{Limit: 2, Sort: test.sort, keyset: true, after: test.after}
+			stmt := db.Scopes(p.Scope()).Find(&[]paginatedItem{}).Statement
+
+			sql := stmt.SQL.String()
+			if !strings.HasSuffix(sql, test.sql) {
+				t.Fatalf("unexpected sql\n%s\nexpected suffix\n%s", sql, test.sql)
+			}
+			if !reflect.DeepEqual(stmt.Vars, test.vars) {
+				t.Fatalf("unexpected vars %v, expected %v", stmt.Vars, test.vars)
+			}
+		})
+	}
+}
+
+func TestCursorFor(t *testing.T) {
+	initializePagination(PaginationSettings{CursorSecret: "secret"})
+	db := dryRunDB(t)
+
+	p := Pagination{Sort: []SortField{{Column: "paginated_items.name"}, {Column: "id"}}, keyset: true}
+	cursor, err := p.cursorFor(db, &paginatedItem{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Sort != "paginated_items.name,id" || !reflect.DeepEqual(payload.Values, []interface{}{nil, int64(7)}) {
+		t.Fatalf("unexpected payload %#v", payload)
+	}
+}
+
+func TestFindPageKeysetNulls(t *testing.T) {
+	initializePagination(PaginationSettings{CursorSecret: "secret", DefaultLimit: 2, MaxLimit: 100})
+
+	db, err := gorm.Open(sqlite.Dialector{DriverName: sqliteDriverName, DSN: ":memory:"}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&paginatedItem{}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"b", "a", "c"}
+	items := []paginatedItem{
+		{ID: 1, Name: &names[0]}, {ID: 2}, {ID: 3, Name: &names[1]},
+		{ID: 4}, {ID: 5, Name: &names[2]}, {ID: 6, Name: &names[1]},
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []string{"name", "-name"} {
+		t.Run(sort, func(t *testing.T) {
+			var (
+				ids    []int64
+				cursor string
+			)
+			for range items {
+				r := httptest.NewRequest("GET", "/items?sort="+sort+"&cursor="+cursor, nil)
+				p, err := ParsePagination(r, PaginationOptions{SortFields: map[string]string{"name": "name"}, Keyset: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				page, err := FindPage[paginatedItem](db, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, item := range page.Data {
+					ids = append(ids, item.ID)
+				}
+				if cursor = page.Meta.NextCursor; cursor == "" {
+					break
+				}
+			}
+
+			expected := []int64{3, 6, 1, 5, 2, 4}
+			if sort == "-name" {
+				expected = []int64{5, 1, 3, 6, 2, 4}
+			}
+			if !reflect.DeepEqual(ids, expected) {
+				t.Fatalf("paginated %v, expected %v", ids, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package webapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	ContentTypeJSON       = "application/json"
+	ContentTypeJSONPatch  = "application/json-patch+json"
+	ContentTypeMergePatch = "application/merge-patch+json"
+)
+
+// Patch holds the result of applying a patch document to an item
+type Patch[T any] struct {
+	Original *T
+	Patched  *T
+	// Columns are the database columns changed by the patch
+	Columns []string
+
+	schema *schema.Schema
+}
+
+// ParsePatch applies the JSON Patch (RFC 6902) or JSON Merge Patch (RFC 7396)
+// in the request body to the current item, plain JSON body is treated as a
+// merge patch. The patched item is left to validate by the caller, e.g. after
+// restoring the fields the client can't change
+func ParsePatch[T any](r *http.Request, db *gorm.DB, current *T) (*Patch[T], error) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		contentType = ContentTypeJSON
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	original, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch contentType {
+	case ContentTypeJSONPatch:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, NewHTTPError(http.StatusBadRequest, "invalid json patch")
+		}
+
+		patched, err = patch.Apply(original)
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, NewHTTPError(http.StatusConflict, err.Error())
+		} else if err != nil {
+			return nil, NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+	case ContentTypeMergePatch, ContentTypeJSON:
+		patched, err = jsonpatch.MergePatch(original, body)
+		if err != nil {
+			return nil, NewHTTPError(http.StatusBadRequest, "invalid merge patch")
+		}
+	default:
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("unsupported content type %s", contentType))
+	}
+
+	stmt := gorm.Statement{DB: db}
+	if err := stmt.Parse(current); err != nil {
+		return nil, err
+	}
+
+	result, err := decodePatched(r.Context(), stmt.Schema, current, patched)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := Patch[T]{
+		Original: current,
+		Patched:  result,
+		schema:   stmt.Schema,
+	}
+
+	if err := patch.diff(r.Context()); err != nil {
+		return nil, err
+	}
+
+	return &patch, nil
+}
+
+// Restore reverts the field (struct or column name) to its original value
+// and excludes it from the changed columns
+func (p *Patch[T]) Restore(ctx context.Context, name string) error {
+	field := p.schema.LookUpField(name)
+	if field == nil {
+		return fmt.Errorf("field %s not found in %s", name, p.schema.Name)
+	}
+
+	var (
+		originalValue = reflect.ValueOf(p.Original).Elem()
+		patchedValue  = reflect.ValueOf(p.Patched).Elem()
+	)
+
+	value := field.ReflectValueOf(ctx, originalValue).Interface()
+	if err := field.Set(ctx, patchedValue, value); err != nil {
+		return err
+	}
+
+	for i, column := range p.Columns {
+		if column == field.DBName {
+			p.Columns = append(p.Columns[:i], p.Columns[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Changed checks whether the field (struct or column name) is changed by the patch
+func (p *Patch[T]) Changed(name string) bool {
+	field := p.schema.LookUpField(name)
+	if field == nil {
+		return false
+	}
+
+	for _, column := range p.Columns {
+		if column == field.DBName {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply updates only the changed columns, it is a no-op when nothing changed.
+// Versioned models fail with VersionConflictError when modified concurrently
+func (p *Patch[T]) Apply(db *gorm.DB) error {
+	if len(p.Columns) == 0 {
+		return nil
+	}
+
+	columns := append([]string{}, p.Columns...)
+	// keep the auto update timestamps up to date
+	for _, field := range p.schema.Fields {
+		if field.AutoUpdateTime > 0 && field.DBName != "" {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	return updateVersioned(db, p.Original, p.Patched, columns)
+}
+
+// diff collects the changed columns and rejects changes to the primary keys
+func (p *Patch[T]) diff(ctx context.Context) error {
+	var (
+		originalValue = reflect.ValueOf(p.Original).Elem()
+		patchedValue  = reflect.ValueOf(p.Patched).Elem()
+	)
+
+	p.Columns = []string{}
+	for _, field := range p.schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+
+		before := field.ReflectValueOf(ctx, originalValue).Interface()
+		after := field.ReflectValueOf(ctx, patchedValue).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+
+		if field.PrimaryKey {
+			return NewHTTPError(http.StatusUnprocessableEntity,
+				fmt.Sprintf("field %s can't be changed", field.Name))
+		}
+		p.Columns = append(p.Columns, field.DBName)
+	}
+
+	return nil
+}
+
+// decodePatched decodes the patched document into a new item, a copy of the
+// current item would share its pointers and maps with the patched one. Fields
+// removed by the patch are left to their zero value, the fields hidden from
+// JSON are kept from the current item
+func decodePatched[T any](ctx context.Context, s *schema.Schema, current *T, patched []byte) (*T, error) {
+	var (
+		result T
+		keys   map[string]json.RawMessage
+	)
+
+	if err := json.Unmarshal(patched, &keys); err != nil || keys == nil {
+		return nil, NewHTTPError(http.StatusUnprocessableEntity, "patched document must be an object")
+	}
+
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return nil, NewHTTPError(http.StatusUnprocessableEntity, "patched document doesn't match the resource")
+	}
+
+	var (
+		currentValue = reflect.ValueOf(current).Elem()
+		resultValue  = reflect.ValueOf(&result).Elem()
+	)
+	for _, field := range s.Fields {
+		if _, ok := jsonFieldName(field.StructField); ok {
+			continue
+		}
+
+		value := field.ReflectValueOf(ctx, currentValue).Interface()
+		if err := field.Set(ctx, resultValue, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// jsonFieldName returns the JSON key of the struct field
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
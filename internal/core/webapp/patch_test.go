@@ -0,0 +1,162 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type patchedItem struct {
+	ID       int64             `json:"id"`
+	Title    string            `json:"title" validate:"required"`
+	Note     *string           `json:"note"`
+	DueAt    *time.Time        `json:"due_at"`
+	Labels   map[string]string `json:"labels" gorm:"serializer:json"`
+	Password string            `json:"-"`
+}
+
+func newPatchedItem() *patchedItem {
+	note := "draft"
+	due := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &patchedItem{
+		ID:       1,
+		Title:    "first",
+		Note:     &note,
+		DueAt:    &due,
+		Labels:   map[string]string{"a": "1", "b": "2"},
+		Password: "secret",
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	db := dryRunDB(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		columns     []string
+	}{
+		{"pointer", ContentTypeMergePatch, `{"note":"published"}`, []string{"note"}},
+		{"time pointer", ContentTypeMergePatch, `{"due_at":"2025-01-01T00:00:00Z"}`, []string{"due_at"}},
+		{"map key removed", ContentTypeMergePatch, `{"labels":{"a":null}}`, []string{"labels"}},
+		{"map key added", ContentTypeJSONPatch, `[{"op":"add","path":"/labels/c","value":"3"}]`, []string{"labels"}},
+		{"pointer removed", ContentTypeJSONPatch, `[{"op":"remove","path":"/note"}]`, []string{"note"}},
+		{"plain json", ContentTypeJSON, `{"title":"second"}`, []string{"title"}},
+		{"unchanged", ContentTypeMergePatch, `{"note":"draft","labels":{"a":"1"}}`, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := newPatchedItem()
+
+			r := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			patch, err := ParsePatch(r, db, current)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(patch.Columns, test.columns) {
+				t.Fatalf("changed %v, expected %v", patch.Columns, test.columns)
+			}
+			if !reflect.DeepEqual(current, newPatchedItem()) {
+				t.Fatalf("the current item is modified: %+v", current)
+			}
+			if patch.Patched.Password != "secret" {
+				t.Fatalf("the hidden field is not kept: %+v", patch.Patched)
+			}
+		})
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	db := dryRunDB(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"primary key", ContentTypeMergePatch, `{"id":2}`, http.StatusUnprocessableEntity},
+		{"not an object", ContentTypeJSONPatch, `[{"op":"replace","path":"","value":null}]`, http.StatusUnprocessableEntity},
+		{"type mismatch", ContentTypeMergePatch, `{"title":1}`, http.StatusUnprocessableEntity},
+		{"failed test", ContentTypeJSONPatch, `[{"op":"test","path":"/title","value":"other"}]`, http.StatusConflict},
+		{"invalid patch", ContentTypeJSONPatch, `{}`, http.StatusBadRequest},
+		{"content type", "text/plain", `{}`, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			_, err := ParsePatch(r, db, newPatchedItem())
+
+			httpErr, ok := err.(*HTTPError)
+			if !ok || httpErr.Status != test.status {
+				t.Fatalf("expected status %d, got %v", test.status, err)
+			}
+		})
+	}
+}
+
+func TestResourcePatch(t *testing.T) {
+	openTestDB(t, "patch", &patchedItem{})
+	if err := DB("patch").Create(newPatchedItem()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	resource := NewResource(ResourceOptions[patchedItem]{
+		DB:       "patch",
+		Writable: []string{"note", "due_at", "labels"},
+	})
+	router := chi.NewRouter()
+	resource.Mount(router, "/items")
+
+	// the non writable title is restored before the validation
+	r := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(`{"title":"","note":"published","labels":{"a":null}}`))
+	r.Header.Set("Content-Type", ContentTypeMergePatch)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var stored patchedItem
+	if err := DB("patch").First(&stored, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "first" || *stored.Note != "published" || !reflect.DeepEqual(stored.Labels, map[string]string{"b": "2"}) {
+		t.Fatalf("unexpected stored item %+v", stored)
+	}
+}
+
+func TestResourcePatchCustomValidate(t *testing.T) {
+	openTestDB(t, "patch", &patchedItem{})
+	if err := DB("patch").Create(newPatchedItem()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the custom validation replaces the default one
+	validated := false
+	resource := NewResource(ResourceOptions[patchedItem]{
+		DB: "patch",
+		Validate: func(r *http.Request, item *patchedItem) error {
+			validated = true
+			return nil
+		},
+	})
+	router := chi.NewRouter()
+	resource.Mount(router, "/items")
+
+	r := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(`{"title":""}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !validated {
+		t.Fatalf("expected 200 from the custom validation, got %d: %s", w.Code, w.Body)
+	}
+}
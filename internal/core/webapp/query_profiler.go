@@ -0,0 +1,249 @@
+package webapp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	queryCountHeader   = "X-Query-Count"
+	serverTimingHeader = "Server-Timing"
+	queryStartKey      = "webapp:query_start"
+
+	defaultRepeatThreshold = 5
+)
+
+type (
+	// queryProfileContextKey holds the query profile of the request
+	queryProfileContextKey struct{}
+
+	// queryProfile counts the queries issued while serving a request
+	queryProfile struct {
+		mu         sync.Mutex
+		count      int
+		elapsed    time.Duration
+		statements map[string]*statementProfile
+		order      []string
+	}
+
+	// statementProfile counts the executions of a statement and its distinct parameters
+	statementProfile struct {
+		count  int
+		params map[string]struct{}
+	}
+
+	// queryProfilerPlugin records the queries of the profiled requests
+	queryProfilerPlugin struct{}
+
+	// profilingResponseWriter adds the query headers before the response is
+	// written, it supports flushing and hijacking when the wrapped writer does
+	profilingResponseWriter struct {
+		http.ResponseWriter
+		profile     *queryProfile
+		wroteHeader bool
+	}
+)
+
+// QueryProfiler counts the queries of every request for development, it warns
+// when the request exceeds the query budget or repeats a statement with different
+// parameters, i.e. N+1 queries. Only the queries using the request context,
+// e.g. through DBContext, are counted
+func QueryProfiler(settings QueryProfilerSettings) Middleware {
+	threshold := settings.RepeatThreshold
+	if threshold <= 0 {
+		threshold = defaultRepeatThreshold
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			profile := queryProfile{statements: make(map[string]*statementProfile)}
+			ctx := context.WithValue(r.Context(), queryProfileContextKey{}, &profile)
+
+			if settings.Headers {
+				w = &profilingResponseWriter{ResponseWriter: w, profile: &profile}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			profile.report(r, settings.MaxQueries, threshold)
+		})
+	}
+}
+
+func queryProfileFromContext(ctx context.Context) (*queryProfile, bool) {
+	profile, ok := ctx.Value(queryProfileContextKey{}).(*queryProfile)
+	return profile, ok
+}
+
+func (p *queryProfile) record(sql string, params string, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.count++
+	p.elapsed += elapsed
+
+	statement, ok := p.statements[sql]
+	if !ok {
+		statement = &statementProfile{params: make(map[string]struct{})}
+		p.statements[sql] = statement
+		p.order = append(p.order, sql)
+	}
+	statement.count++
+	statement.params[params] = struct{}{}
+}
+
+// stats returns the number of queries and their total duration
+func (p *queryProfile) stats() (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count, p.elapsed
+}
+
+// report logs the exceeded query budget and the repeated statements of the request
+func (p *queryProfile) report(r *http.Request, maxQueries int, threshold int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+
+	if maxQueries > 0 && p.count > maxQueries {
+		log.Warning("query budget exceeded",
+			log.WithContext(r.Context()),
+			log.WithField("method", r.Method),
+			log.WithField("route", route),
+			log.WithField("queries", p.count),
+			log.WithField("budget", maxQueries),
+			log.WithField("elapsed", p.elapsed.String()),
+		)
+	}
+
+	for _, sql := range p.order {
+		statement := p.statements[sql]
+		if statement.count < threshold || len(statement.params) < 2 {
+			continue
+		}
+
+		log.Warning("possible N+1 query",
+			log.WithContext(r.Context()),
+			log.WithField("method", r.Method),
+			log.WithField("route", route),
+			log.WithField("sql", sql),
+			log.WithField("count", statement.count),
+		)
+	}
+}
+
+func (w *profilingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		// the queries issued after the headers are written are only logged
+		count, elapsed := w.profile.stats()
+		header := w.Header()
+		header.Set(queryCountHeader, strconv.Itoa(count))
+		header.Add(serverTimingHeader, fmt.Sprintf(`db;dur=%.2f;desc="%d queries"`,
+			float64(elapsed)/float64(time.Millisecond), count))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *profilingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, e.g. for server sent events
+func (w *profilingResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, e.g. for websockets
+func (w *profilingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *profilingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Name implements gorm.Plugin
+func (queryProfilerPlugin) Name() string {
+	return "webapp:query_profiler"
+}
+
+// Initialize implements gorm.Plugin, the queries are recorded only when the
+// statement context is profiled
+func (queryProfilerPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Create().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+		callback.Query().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Query().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+		callback.Update().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Update().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+		callback.Delete().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Delete().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+		callback.Row().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Row().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+		callback.Raw().Before("*").Register("webapp:query_profiler_start", startQueryProfile),
+		callback.Raw().After("*").Register("webapp:query_profiler_end", endQueryProfile),
+	)
+}
+
+func startQueryProfile(db *gorm.DB) {
+	if ctx := db.Statement.Context; ctx != nil {
+		if _, ok := queryProfileFromContext(ctx); ok {
+			db.InstanceSet(queryStartKey, time.Now())
+		}
+	}
+}
+
+func endQueryProfile(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		return
+	}
+
+	profile, ok := queryProfileFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	// skipped statements, e.g. on errors before building the sql
+	sql := db.Statement.SQL.String()
+	if sql == "" {
+		return
+	}
+
+	var elapsed time.Duration
+	if start, ok := db.InstanceGet(queryStartKey); ok {
+		elapsed = time.Since(start.(time.Time))
+	}
+	profile.record(sql, fmt.Sprint(db.Statement.Vars...), elapsed)
+}
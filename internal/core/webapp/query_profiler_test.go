@@ -0,0 +1,42 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryProfilerPluginRegistration(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		settings := DatabaseSettings{Uri: "sqlite://:memory:", queryProfiler: enabled}
+		db, sqlDb, err := openGorm(settings, "profiler")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDb.Close()
+
+		registered := db.Callback().Query().Get("webapp:query_profiler_start") != nil
+		if registered != enabled {
+			t.Fatalf("enabled %v: expected the plugin registered %v", enabled, enabled)
+		}
+	}
+}
+
+func TestQueryProfilerFlush(t *testing.T) {
+	handler := QueryProfiler(QueryProfilerSettings{Headers: true})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := w.(http.Hijacker); !ok {
+				t.Fatal("expected the writer to implement http.Hijacker")
+			}
+		}),
+	)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !w.Flushed || w.Header().Get(queryCountHeader) != "0" {
+		t.Fatalf("expected the flushed response with the query headers, got %v %v", w.Flushed, w.Header())
+	}
+}
@@ -0,0 +1,349 @@
+package webapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	ReplicaPolicyRandom     = "random"
+	ReplicaPolicyRoundRobin = "round_robin"
+	ReplicaPolicyLeastLag   = "least_lag"
+
+	defaultReplicaCheckInterval = 10 * time.Second
+	replicaCheckTimeout         = 5 * time.Second
+)
+
+var (
+	// for holds the replicas of the database connections, guarded by dbMu
+	dbReplicas = make(map[string]*replicaSet)
+)
+
+type (
+	// primaryContextKey forces the reads to the primary
+	primaryContextKey struct{}
+
+	// replica tracks the health and lag of a read replica
+	replica struct {
+		name    string
+		conn    *sql.DB
+		healthy atomic.Bool
+		lag     atomic.Int64
+		err     atomic.Value
+	}
+
+	// replicaSet routes the reads to the available replicas using the policy,
+	// falls back to the primary when none is available
+	replicaSet struct {
+		driver   string
+		policy   string
+		maxLag   time.Duration
+		interval time.Duration
+		primary  gorm.ConnPool
+		replicas []*replica
+		byConn   map[gorm.ConnPool]*replica
+		next     atomic.Uint64
+		stop     chan struct{}
+		wg       sync.WaitGroup
+	}
+
+	// errorValue wraps errors of different types stored in atomic.Value
+	errorValue struct {
+		err error
+	}
+)
+
+// ContextWithPrimary routes the reads using the context to the primary,
+// e.g. to read the own writes regardless of the replica lag
+func ContextWithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func primaryFromContext(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryContextKey{}).(bool)
+	return primary
+}
+
+// useReplicas routes the reads of the database to its replicas, the writes
+// and transactions keep using the primary
+func useReplicas(db *gorm.DB, settings DatabaseSettings, name string) (*replicaSet, error) {
+	driver, err := settings.DriverName()
+	if err != nil {
+		return nil, err
+	}
+
+	primary, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	set := replicaSet{
+		driver:   driver,
+		policy:   settings.ReplicaPolicy,
+		maxLag:   settings.MaxReplicaLag,
+		interval: settings.ReplicaCheckInterval,
+		primary:  primary,
+		byConn:   make(map[gorm.ConnPool]*replica),
+		stop:     make(chan struct{}),
+	}
+	if set.interval <= 0 {
+		set.interval = defaultReplicaCheckInterval
+	}
+
+	switch set.policy {
+	case "", ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyLeastLag:
+	default:
+		return nil, fmt.Errorf("unsupported replica policy %s", set.policy)
+	}
+
+	// the primary is a candidate too so the policy always decides
+	dialectors := []gorm.Dialector{connDialector(driver, primary)}
+	for i, uri := range settings.Replicas {
+		replicaSettings := settings
+		replicaSettings.Uri = uri
+		replicaSettings.Replicas = nil
+
+		replicaName := fmt.Sprintf("%s.replica%d", name, i)
+		conn, err := openConn(replicaSettings, replicaName)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("failed to open replica %d: %w", i, err)
+		}
+
+		r := &replica{name: replicaName, conn: conn}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+		set.byConn[conn] = r
+		dialectors = append(dialectors, connDialector(driver, conn))
+	}
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   &set,
+	}))
+	if err != nil {
+		set.close()
+		return nil, err
+	}
+
+	// force the primary before the resolver picks a replica, registered after
+	// the resolver so it is sorted first
+	err = errors.Join(
+		db.Callback().Query().Before("*").Register("webapp:force_primary", forcePrimary),
+		db.Callback().Row().Before("*").Register("webapp:force_primary", forcePrimary),
+		db.Callback().Raw().Before("*").Register("webapp:force_primary", forcePrimary),
+	)
+	if err != nil {
+		set.close()
+		return nil, err
+	}
+
+	set.check()
+	set.wg.Add(1)
+	go set.run()
+	return &set, nil
+}
+
+func forcePrimary(db *gorm.DB) {
+	if ctx := db.Statement.Context; ctx != nil && primaryFromContext(ctx) {
+		dbresolver.Write.ModifyStatement(db.Statement)
+	}
+}
+
+// Resolve implements dbresolver.Policy
+func (s *replicaSet) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	available := make([]*replica, 0, len(pools))
+	for _, pool := range pools {
+		if r, ok := s.byConn[pool]; ok && r.available(s.maxLag) {
+			available = append(available, r)
+		}
+	}
+
+	switch {
+	case len(available) == 0:
+		return s.primary
+	case len(available) == 1:
+		return available[0].conn
+	}
+
+	switch s.policy {
+	case ReplicaPolicyRoundRobin:
+		return available[s.next.Add(1)%uint64(len(available))].conn
+	case ReplicaPolicyLeastLag:
+		least := available[0]
+		for _, r := range available[1:] {
+			if r.lag.Load() < least.lag.Load() {
+				least = r
+			}
+		}
+		return least.conn
+	default:
+		return available[rand.IntN(len(available))].conn
+	}
+}
+
+func (s *replicaSet) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+// check updates the health and lag of every replica
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+		lag, err := replicaLag(ctx, s.driver, r.conn)
+		cancel()
+
+		if err == nil && s.maxLag > 0 && lag > s.maxLag {
+			err = fmt.Errorf("replica lag %s exceeds %s", lag, s.maxLag)
+		}
+
+		r.lag.Store(int64(lag))
+		r.err.Store(errorValue{err})
+		healthy := err == nil
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		if healthy {
+			log.Info("database replica recovered", log.WithField("name", r.name))
+		} else {
+			log.Warning("database replica unavailable, excluded from reads",
+				log.WithField("name", r.name),
+				log.WithError(err),
+			)
+		}
+	}
+}
+
+func (s *replicaSet) close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.wg.Wait()
+
+	for _, r := range s.replicas {
+		if err := r.conn.Close(); err != nil {
+			log.Error("failed to close the database replica connection",
+				log.WithField("name", r.name),
+				log.WithError(err),
+			)
+		}
+	}
+}
+
+func (r *replica) available(maxLag time.Duration) bool {
+	return r.healthy.Load() && (maxLag <= 0 || time.Duration(r.lag.Load()) <= maxLag)
+}
+
+// Err returns the error of the last health check
+func (r *replica) Err() error {
+	if v, ok := r.err.Load().(errorValue); ok {
+		return v.err
+	}
+	return nil
+}
+
+// replicaLag returns how far the replica is behind the primary
+func replicaLag(ctx context.Context, driver string, conn *sql.DB) (time.Duration, error) {
+	if err := conn.PingContext(ctx); err != nil {
+		return 0, err
+	}
+
+	switch driver {
+	case DriverPostgres:
+		// zero when not in recovery, i.e. not a replica, or when every received
+		// wal is replayed as the replay timestamp ages while the primary is idle
+		var seconds float64
+		err := conn.QueryRowContext(ctx, `SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`).Scan(&seconds)
+		return time.Duration(seconds * float64(time.Second)), err
+	case DriverMySQL:
+		return mysqlReplicaLag(ctx, conn)
+	default:
+		return 0, nil
+	}
+}
+
+func mysqlReplicaLag(ctx context.Context, conn *sql.DB) (time.Duration, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// not a replica
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+
+		// null when the replication is not running
+		if values[i] == nil {
+			return 0, errors.New("replication is not running")
+		}
+
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		return time.Duration(seconds) * time.Second, err
+	}
+
+	return 0, nil
+}
+
+// connDialector returns the dialector of the driver using the opened connection
+func connDialector(driver string, conn gorm.ConnPool) gorm.Dialector {
+	switch driver {
+	case DriverMySQL:
+		return mysql.New(mysql.Config{Conn: conn})
+	case DriverSQLite:
+		return sqlite.Dialector{DriverName: sqliteDriverName, Conn: conn}
+	default:
+		return postgres.New(postgres.Config{Conn: conn})
+	}
+}
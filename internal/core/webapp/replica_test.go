@@ -0,0 +1,168 @@
+package webapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestReplicaSetResolve(t *testing.T) {
+	open := func() *sql.DB {
+		conn, err := sql.Open(sqliteDriverName, ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// the replicas are available unless unhealthy or lagging behind the max lag
+	type replicaState struct {
+		healthy bool
+		lag     time.Duration
+	}
+	newSet := func(policy string, states ...replicaState) (*replicaSet, []gorm.ConnPool) {
+		set := &replicaSet{
+			policy:  policy,
+			maxLag:  time.Second,
+			primary: open(),
+			byConn:  make(map[gorm.ConnPool]*replica),
+		}
+		pools := []gorm.ConnPool{set.primary}
+		for _, state := range states {
+			r := &replica{conn: open()}
+			r.healthy.Store(state.healthy)
+			r.lag.Store(int64(state.lag))
+			set.replicas = append(set.replicas, r)
+			set.byConn[r.conn] = r
+			pools = append(pools, r.conn)
+		}
+		return set, pools
+	}
+
+	t.Run("fallback to the primary", func(t *testing.T) {
+		set, pools := newSet(ReplicaPolicyRandom,
+			replicaState{healthy: false},
+			replicaState{healthy: true, lag: time.Minute},
+		)
+		if set.Resolve(pools) != set.primary {
+			t.Fatal("expected the primary when no replica is available")
+		}
+	})
+
+	t.Run("single available replica", func(t *testing.T) {
+		set, pools := newSet(ReplicaPolicyLeastLag,
+			replicaState{healthy: false},
+			replicaState{healthy: true, lag: time.Millisecond},
+		)
+		if set.Resolve(pools) != set.replicas[1].conn {
+			t.Fatal("expected the available replica")
+		}
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		set, pools := newSet(ReplicaPolicyRoundRobin,
+			replicaState{healthy: true},
+			replicaState{healthy: false},
+			replicaState{healthy: true},
+		)
+
+		counts := make(map[gorm.ConnPool]int)
+		for range 4 {
+			counts[set.Resolve(pools)]++
+		}
+		if counts[set.replicas[0].conn] != 2 || counts[set.replicas[2].conn] != 2 {
+			t.Fatalf("expected the available replicas in turn, got %v", counts)
+		}
+	})
+
+	t.Run("least lag", func(t *testing.T) {
+		set, pools := newSet(ReplicaPolicyLeastLag,
+			replicaState{healthy: true, lag: 500 * time.Millisecond},
+			replicaState{healthy: true, lag: 10 * time.Millisecond},
+			replicaState{healthy: true, lag: 2 * time.Second},
+		)
+		if set.Resolve(pools) != set.replicas[1].conn {
+			t.Fatal("expected the least lagging replica")
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		set, pools := newSet(ReplicaPolicyRandom,
+			replicaState{healthy: true},
+			replicaState{healthy: false},
+		)
+		for range 10 {
+			if set.Resolve(pools) != set.replicas[0].conn {
+				t.Fatal("expected only the available replica")
+			}
+		}
+	})
+}
+
+func TestReplicaReads(t *testing.T) {
+	type replicatedItem struct {
+		ID     int64
+		Source string
+	}
+
+	// the primary and the replica hold different rows to tell them apart
+	dir := t.TempDir()
+	seed := func(path string, source string) {
+		db, err := gorm.Open(sqlite.Dialector{DriverName: sqliteDriverName, DSN: path}, &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeGorm(db)
+
+		if err := db.AutoMigrate(&replicatedItem{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&replicatedItem{ID: 1, Source: source}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed(filepath.Join(dir, "primary.db"), "primary")
+	seed(filepath.Join(dir, "replica.db"), "replica")
+
+	settings := DatabaseSettings{
+		Uri:      "sqlite://" + filepath.Join(dir, "primary.db"),
+		Replicas: []string{"sqlite://" + filepath.Join(dir, "replica.db")},
+	}
+	if err := OpenDB(settings, "replicated"); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB("replicated")
+
+	read := func(ctx context.Context) string {
+		var item replicatedItem
+		if err := DBContext(ctx, "replicated").First(&item).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item.Source
+	}
+
+	if source := read(context.Background()); source != "replica" {
+		t.Fatalf("expected the read from the replica, got %s", source)
+	}
+	if source := read(ContextWithPrimary(context.Background())); source != "primary" {
+		t.Fatalf("expected the forced read from the primary, got %s", source)
+	}
+
+	// the transactions keep using the primary
+	err := WithTx(context.Background(), func(ctx context.Context) error {
+		if source := read(ctx); source != "primary" {
+			return errors.New("expected the read in the transaction from the primary, got " + source)
+		}
+		return nil
+	}, WithTxDB("replicated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// Scope is a reusable gorm query condition
+	Scope func(*gorm.DB) *gorm.DB
+
+	// Repository provides the common queries of the model T, all queries
+	// join the transaction stored in the context if any
+	Repository[T any] struct {
+		name string
+	}
+)
+
+// NewRepository creates a repository of T using the named database, default to the default connection
+func NewRepository[T any](names ...string) *Repository[T] {
+	name := defaultDbName
+	if len(names) > 0 {
+		name = names[0]
+	}
+
+	return &Repository[T]{name: name}
+}
+
+// DB returns the database session of the repository bound to the context
+func (r *Repository[T]) DB(ctx context.Context) *gorm.DB {
+	return DBContext(ctx, r.name)
+}
+
+// WithTx runs fn in a transaction of the repository's database
+func (r *Repository[T]) WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	opts = append([]TxOption{WithTxDB(r.name)}, opts...)
+	return WithTx(ctx, fn, opts...)
+}
+
+// FindByID finds the item by its primary key
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	return r.First(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id})
+	})
+}
+
+// First finds the first item matching the scopes
+func (r *Repository[T]) First(ctx context.Context, scopes ...Scope) (*T, error) {
+	var item T
+	if err := r.query(ctx, scopes).First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// Find finds all items matching the scopes
+func (r *Repository[T]) Find(ctx context.Context, scopes ...Scope) ([]T, error) {
+	var items []T
+	if err := r.query(ctx, scopes).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// FindPage finds the requested page of items matching the scopes
+func (r *Repository[T]) FindPage(ctx context.Context, p *Pagination, scopes ...Scope) (*Page[T], error) {
+	return FindPage[T](r.query(ctx, scopes), p)
+}
+
+// Count counts the items matching the scopes
+func (r *Repository[T]) Count(ctx context.Context, scopes ...Scope) (int64, error) {
+	var count int64
+	err := r.query(ctx, scopes).Model(new(T)).Count(&count).Error
+	return count, err
+}
+
+// Exists checks whether any item matches the scopes
+func (r *Repository[T]) Exists(ctx context.Context, scopes ...Scope) (bool, error) {
+	_, err := r.First(ctx, scopes...)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (r *Repository[T]) Create(ctx context.Context, items ...*T) error {
+	for _, item := range items {
+		if err := r.DB(ctx).Create(item).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save updates all fields of the item, creates it when the primary key is zero
+func (r *Repository[T]) Save(ctx context.Context, item *T) error {
+	return r.DB(ctx).Save(item).Error
+}
+
+// Update updates the selected columns of the item, all non-zero fields when no column is selected
+func (r *Repository[T]) Update(ctx context.Context, item *T, columns ...string) error {
+	db := r.DB(ctx).Model(item)
+	if len(columns) > 0 {
+		db = db.Select(columns)
+	}
+
+	return db.Updates(item).Error
+}
+
+// UpdateVersioned updates current with the values of updated, failing with
+// VersionConflictError when the version of a versioned model changed
+func (r *Repository[T]) UpdateVersioned(ctx context.Context, current *T, updated *T, columns ...string) error {
+	return UpdateVersioned(r.DB(ctx), current, updated, columns...)
+}
+
+// Delete deletes the item, versioned models are only deleted when the version still matches
+func (r *Repository[T]) Delete(ctx context.Context, item *T) error {
+	return DeleteVersioned(r.DB(ctx), item)
+}
+
+// DeleteByID deletes the item by its primary key
+func (r *Repository[T]) DeleteByID(ctx context.Context, id interface{}) error {
+	return r.DB(ctx).
+		Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).
+		Delete(new(T)).Error
+}
+
+func (r *Repository[T]) query(ctx context.Context, scopes []Scope) *gorm.DB {
+	db := r.DB(ctx).Model(new(T))
+	for _, scope := range scopes {
+		db = scope(db)
+	}
+
+	return db
+}
@@ -0,0 +1,465 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/validator"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	ResourceList   ResourceAction = "list"
+	ResourceGet    ResourceAction = "get"
+	ResourceCreate ResourceAction = "create"
+	ResourceUpdate ResourceAction = "update"
+	ResourceDelete ResourceAction = "delete"
+)
+
+const resourceIDParam = "id"
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+type (
+	// ResourceAction is the action performed on a resource
+	ResourceAction string
+
+	// ResourceOptions configures a resource and its hooks, all hooks are optional
+	ResourceOptions[T any] struct {
+		// DB is the database connection name, default to the default connection
+		DB         string
+		Pagination PaginationOptions
+		Filter     FilterOptions
+		// Writable whitelists the fields (struct or column name) that can be set
+		// by the client, all fields except the primary key and the managed
+		// timestamps are writable when empty
+		Writable []string
+
+		// Scope restricts the rows accessible for the request, e.g. by tenant
+		Scope func(r *http.Request) func(*gorm.DB) *gorm.DB
+		// Authorize is called before performing the action, item is nil for list
+		// and the stored item for delete. Update is authorized for both the
+		// stored and the updated item, returning a non HTTPError results in 403
+		Authorize func(r *http.Request, action ResourceAction, item *T) error
+		// Validate validates the item before persisted, default to validator.Validate
+		Validate func(r *http.Request, item *T) error
+		// Present controls the fields visible to the client, default to the item itself
+		Present func(r *http.Request, item *T) interface{}
+	}
+
+	// Resource exposes CRUD endpoints of the GORM model T
+	Resource[T any] struct {
+		opts ResourceOptions[T]
+	}
+)
+
+// NewResource creates a resource of the model T
+func NewResource[T any](opts ResourceOptions[T]) *Resource[T] {
+	return &Resource[T]{opts: opts}
+}
+
+// Mount registers the list, get, create, update and delete endpoints under the pattern
+func (res *Resource[T]) Mount(router chi.Router, pattern string) {
+	router.Route(pattern, func(r chi.Router) {
+		r.Get("/", res.list)
+		r.Post("/", res.create)
+		r.Get("/{id}", res.get)
+		r.Put("/{id}", res.update)
+		r.Patch("/{id}", res.patch)
+		r.Delete("/{id}", res.delete)
+	})
+}
+
+func (res *Resource[T]) list(w http.ResponseWriter, r *http.Request) {
+	pagination, err := ParsePagination(r, res.opts.Pagination)
+	if err != nil {
+		res.writeError(w, r, NewHTTPError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	filter, err := ParseFilter(r, res.opts.Filter)
+	if err != nil {
+		res.writeError(w, r, NewHTTPError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	if err := res.authorize(r, ResourceList, nil); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	page, err := FindPage[T](res.db(r).Scopes(filter.Scope()), pagination)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	// present every item of the page
+	presented := Page[interface{}]{
+		Data:       make([]interface{}, len(page.Data)),
+		Meta:       page.Meta,
+		pagination: page.pagination,
+	}
+	for i := range page.Data {
+		presented.Data[i] = res.present(r, &page.Data[i])
+	}
+
+	WritePage(w, r, &presented)
+}
+
+func (res *Resource[T]) get(w http.ResponseWriter, r *http.Request) {
+	item, err := res.find(r)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.authorize(r, ResourceGet, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if !res.checkPreconditions(w, r, item) {
+		return
+	}
+
+	WriteJSON(w, res.present(r, item))
+}
+
+func (res *Resource[T]) create(w http.ResponseWriter, r *http.Request) {
+	var base T
+	item, err := res.decode(r, &base)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.authorize(r, ResourceCreate, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.validate(r, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.db(r).Create(item).Error; err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	res.setETag(w, item)
+	WriteJSON(w, res.present(r, item), http.StatusCreated)
+}
+
+func (res *Resource[T]) update(w http.ResponseWriter, r *http.Request) {
+	existing, err := res.find(r)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	// authorize before the preconditions to not leak the version
+	if err := res.authorize(r, ResourceUpdate, existing); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if !res.checkPreconditions(w, r, existing) {
+		return
+	}
+
+	item, err := res.decode(r, existing)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	// the body may change e.g. the owner of the item
+	if err := res.authorize(r, ResourceUpdate, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := checkVersion(existing, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.validate(r, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := UpdateVersioned(res.db(r), existing, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	res.setETag(w, item)
+	WriteJSON(w, res.present(r, item))
+}
+
+// patch applies a JSON Patch or JSON Merge Patch, only the changed columns are updated
+func (res *Resource[T]) patch(w http.ResponseWriter, r *http.Request) {
+	existing, err := res.find(r)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.authorize(r, ResourceUpdate, existing); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if !res.checkPreconditions(w, r, existing) {
+		return
+	}
+
+	patch, err := ParsePatch(r, res.db(r), existing)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	// keep the non writable fields untouched
+	for _, field := range patch.schema.Fields {
+		if field.DBName == "" || res.writable(field) || !patch.Changed(field.DBName) {
+			continue
+		}
+
+		if err := patch.Restore(r.Context(), field.DBName); err != nil {
+			res.writeError(w, r, err)
+			return
+		}
+	}
+
+	if err := res.authorize(r, ResourceUpdate, patch.Patched); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := checkVersion(existing, patch.Patched); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.validate(r, patch.Patched); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := patch.Apply(res.db(r)); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	res.setETag(w, patch.Patched)
+	WriteJSON(w, res.present(r, patch.Patched))
+}
+
+func (res *Resource[T]) delete(w http.ResponseWriter, r *http.Request) {
+	item, err := res.find(r)
+	if err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if err := res.authorize(r, ResourceDelete, item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	if !res.checkPreconditions(w, r, item) {
+		return
+	}
+
+	if err := DeleteVersioned(res.db(r), item); err != nil {
+		res.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// db returns the database session scoped for the request, joins the
+// transaction in the request context if any
+func (res *Resource[T]) db(r *http.Request) *gorm.DB {
+	db := DBContext(r.Context(), res.dbName())
+	if res.opts.Scope != nil {
+		db = db.Scopes(res.opts.Scope(r))
+	}
+	return db
+}
+
+func (res *Resource[T]) dbName() string {
+	if res.opts.DB == "" {
+		return defaultDbName
+	}
+	return res.opts.DB
+}
+
+// find loads the item identified by the id url parameter
+func (res *Resource[T]) find(r *http.Request) (*T, error) {
+	var item T
+	id := chi.URLParam(r, resourceIDParam)
+	err := res.db(r).
+		Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).
+		First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// decode reads the request body on top of the base item, only the writable
+// fields are taken from the body
+func (res *Resource[T]) decode(r *http.Request, base *T) (*T, error) {
+	// decode on a deep copy, a shallow copy shares the pointers and maps of the
+	// base with the body
+	var decoded T
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&decoded); err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	stmt := gorm.Statement{DB: DB(res.dbName())}
+	if err := stmt.Parse(base); err != nil {
+		return nil, err
+	}
+
+	var (
+		ctx          = r.Context()
+		result       = *base
+		resultValue  = reflect.ValueOf(&result).Elem()
+		decodedValue = reflect.ValueOf(&decoded).Elem()
+	)
+
+	for _, field := range stmt.Schema.Fields {
+		// the fields hidden from json are never set by the client
+		if _, ok := jsonFieldName(field.StructField); !ok || !res.writable(field) {
+			continue
+		}
+
+		value := field.ReflectValueOf(ctx, decodedValue).Interface()
+		if err := field.Set(ctx, resultValue, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+func (res *Resource[T]) writable(field *schema.Field) bool {
+	// skip the managed fields by default
+	if len(res.opts.Writable) == 0 {
+		return field.DBName != "" &&
+			!field.PrimaryKey &&
+			field.Creatable && field.Updatable &&
+			field.AutoCreateTime == 0 && field.AutoUpdateTime == 0 &&
+			field.FieldType != deletedAtType
+	}
+
+	return slices.Contains(res.opts.Writable, field.Name) ||
+		slices.Contains(res.opts.Writable, field.DBName)
+}
+
+func (res *Resource[T]) authorize(r *http.Request, action ResourceAction, item *T) error {
+	if res.opts.Authorize == nil {
+		return nil
+	}
+
+	err := res.opts.Authorize(r, action, item)
+	if err == nil {
+		return nil
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return err
+	}
+	return NewHTTPError(http.StatusForbidden, err.Error())
+}
+
+func (res *Resource[T]) validate(r *http.Request, item *T) error {
+	var err error
+	if res.opts.Validate != nil {
+		err = res.opts.Validate(r, item)
+	} else {
+		err = validator.Validate(item)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	if fields, ok := validator.FieldErrors(err); ok {
+		return NewHTTPError(http.StatusUnprocessableEntity, "validation failed", fields)
+	}
+	return NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+}
+
+// checkPreconditions sets the ETag of the item and evaluates the conditional headers
+func (res *Resource[T]) checkPreconditions(w http.ResponseWriter, r *http.Request, item *T) bool {
+	etag, err := ETag(item)
+	if err != nil {
+		res.writeError(w, r, err)
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	return CheckPreconditions(w, r, etag)
+}
+
+func (res *Resource[T]) setETag(w http.ResponseWriter, item *T) {
+	if etag, err := ETag(item); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+func (res *Resource[T]) present(r *http.Request, item *T) interface{} {
+	if res.opts.Present == nil {
+		return item
+	}
+	return res.opts.Present(r, item)
+}
+
+// writeError writes err as a consistent JSON error, hiding unexpected errors
+func (res *Resource[T]) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = NewHTTPError(http.StatusNotFound, "resource not found")
+	case errors.Is(err, ErrVersionConflict):
+		err = NewHTTPError(http.StatusConflict, "resource has been modified")
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		err = NewHTTPError(http.StatusConflict, "resource already exists")
+	default:
+		log.Error("resource request failed",
+			log.WithContext(r.Context()),
+			log.WithField("path", r.URL.Path),
+			log.WithError(err),
+		)
+		err = NewHTTPError(http.StatusInternalServerError, "internal server error")
+	}
+
+	WriteError(w, err)
+}
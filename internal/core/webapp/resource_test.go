@@ -0,0 +1,152 @@
+package webapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ownedItem struct {
+	ID    int64   `json:"id"`
+	Owner string  `json:"owner"`
+	Title string  `json:"title" validate:"required"`
+	Note  *string `json:"note"`
+}
+
+// openTestDB opens an in memory sqlite database under the name, closed along the test
+func openTestDB(t *testing.T, name string, models ...interface{}) {
+	t.Helper()
+
+	settings := DatabaseSettings{Uri: "sqlite://:memory:", MaxOpenConns: 1, MaxIdleConns: 1}
+	if err := OpenDB(settings, name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDB(name) })
+
+	if err := DB(name).AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newOwnedResource(t *testing.T) http.Handler {
+	t.Helper()
+
+	openTestDB(t, "resource", &ownedItem{})
+	note := "draft"
+	if err := DB("resource").Create(&ownedItem{ID: 1, Owner: "alice", Title: "first", Note: &note}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	resource := NewResource(ResourceOptions[ownedItem]{
+		DB: "resource",
+		Authorize: func(r *http.Request, action ResourceAction, item *ownedItem) error {
+			if item != nil && item.Owner != r.Header.Get("X-User") {
+				return errors.New("not the owner")
+			}
+			return nil
+		},
+	})
+
+	router := chi.NewRouter()
+	resource.Mount(router, "/items")
+	return router
+}
+
+func TestResourceUpdateAuthorizesStoredItem(t *testing.T) {
+	router := newOwnedResource(t)
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		user        string
+		ifMatch     string
+		body        string
+		status      int
+	}{
+		{"put taking over", http.MethodPut, ContentTypeJSON, "mallory", "", `{"owner":"mallory","title":"mine"}`, http.StatusForbidden},
+		{"patch taking over", http.MethodPatch, ContentTypeMergePatch, "mallory", "", `{"owner":"mallory"}`, http.StatusForbidden},
+		{"put with stale version", http.MethodPut, ContentTypeJSON, "mallory", `"stale"`, `{"owner":"mallory","title":"mine"}`, http.StatusForbidden},
+		{"patch with stale version", http.MethodPatch, ContentTypeMergePatch, "mallory", `"stale"`, `{"title":"mine"}`, http.StatusForbidden},
+		{"put giving away", http.MethodPut, ContentTypeJSON, "alice", "", `{"owner":"bob","title":"theirs"}`, http.StatusForbidden},
+		{"patch giving away", http.MethodPatch, ContentTypeMergePatch, "alice", "", `{"owner":"bob"}`, http.StatusForbidden},
+		{"put by the owner", http.MethodPut, ContentTypeJSON, "alice", "", `{"owner":"alice","title":"updated"}`, http.StatusOK},
+		{"patch by the owner", http.MethodPatch, ContentTypeMergePatch, "alice", "", `{"title":"patched"}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/items/1", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			r.Header.Set("X-User", test.user)
+			if test.ifMatch != "" {
+				r.Header.Set("If-Match", test.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+			if test.user != "alice" && w.Header().Get("ETag") != "" {
+				t.Fatal("expected no ETag for the unauthorized request")
+			}
+		})
+	}
+
+	var stored ownedItem
+	if err := DB("resource").First(&stored, 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Owner != "alice" || stored.Title != "patched" {
+		t.Fatalf("unexpected stored item %+v", stored)
+	}
+}
+
+func TestResourceValidationUsesJSONNames(t *testing.T) {
+	router := newOwnedResource(t)
+
+	r := httptest.NewRequest(http.MethodPut, "/items/1", strings.NewReader(`{"title":""}`))
+	r.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Details []struct {
+			Field string `json:"field"`
+			Tag   string `json:"tag"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Details) != 1 || response.Details[0].Field != "title" || response.Details[0].Tag != "required" {
+		t.Fatalf("unexpected details %+v", response.Details)
+	}
+}
+
+func TestResourceDecodeKeepsStoredItem(t *testing.T) {
+	openTestDB(t, "resource", &ownedItem{})
+
+	// the note pointer of the stored item must not be written through
+	resource := NewResource(ResourceOptions[ownedItem]{DB: "resource", Writable: []string{"title"}})
+	note := "draft"
+	base := ownedItem{ID: 1, Owner: "alice", Title: "first", Note: &note}
+
+	r := httptest.NewRequest(http.MethodPut, "/items/1", strings.NewReader(`{"title":"new","note":"changed"}`))
+	item, err := resource.decode(r, &base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "draft" || *item.Note != "draft" || item.Title != "new" {
+		t.Fatalf("decode changed the non writable note: %q, %+v", note, item)
+	}
+}
@@ -2,9 +2,42 @@ package webapp
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
+type (
+	// ErrorResponse is the JSON body written by WriteError
+	ErrorResponse struct {
+		Error   string      `json:"error"`
+		Details interface{} `json:"details,omitempty"`
+	}
+
+	// HTTPError is an error that carries its HTTP status
+	HTTPError struct {
+		Status  int
+		Message string
+		Details interface{}
+	}
+)
+
+func NewHTTPError(status int, message string, details ...interface{}) *HTTPError {
+	err := HTTPError{
+		Status:  status,
+		Message: message,
+	}
+
+	if len(details) > 0 {
+		err.Details = details[0]
+	}
+
+	return &err
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
 func WriteJSON(w http.ResponseWriter, data interface{}, statuses ...int) {
 	status := http.StatusOK
 	if len(statuses) > 0 {
@@ -15,3 +48,24 @@ func WriteJSON(w http.ResponseWriter, data interface{}, statuses ...int) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// WriteError writes the error as a JSON response, the status is taken from
+// the HTTPError if not supplied and default to 500
+func WriteError(w http.ResponseWriter, err error, statuses ...int) {
+	var (
+		httpErr  *HTTPError
+		status   = http.StatusInternalServerError
+		response = ErrorResponse{Error: err.Error()}
+	)
+
+	if errors.As(err, &httpErr) {
+		status = httpErr.Status
+		response.Details = httpErr.Details
+	}
+
+	if len(statuses) > 0 {
+		status = statuses[0]
+	}
+
+	WriteJSON(w, response, status)
+}
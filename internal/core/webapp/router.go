@@ -0,0 +1,302 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// appOwner owns the routes registered by the app itself
+	appOwner  = "webapp"
+	anyMethod = "*"
+)
+
+var routeParamRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+type (
+	// RouteEntry is a route registered by a module
+	RouteEntry struct {
+		Method  string
+		Pattern string
+		Module  string
+	}
+
+	// routeRegistry tracks the owner of every registered route and mount point
+	routeRegistry struct {
+		entries   []RouteEntry
+		routes    map[string]string
+		mounts    map[string]string
+		conflicts []error
+	}
+
+	// routeRecorder records the routes registered through the router before
+	// delegating, conflicting routes are reported instead of registered
+	routeRecorder struct {
+		chi.Router
+		registry *routeRegistry
+		module   string
+		prefix   string
+	}
+)
+
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{
+		routes: make(map[string]string),
+		mounts: make(map[string]string),
+	}
+}
+
+// Entries returns the registered routes in registration order
+func (reg *routeRegistry) Entries() []RouteEntry {
+	return reg.entries
+}
+
+// Owner returns the module that registered the method and pattern
+func (reg *routeRegistry) Owner(method string, pattern string) (string, bool) {
+	pattern = normalizeRoutePattern(pattern)
+	if owner, ok := reg.routes[method+" "+pattern]; ok {
+		return owner, true
+	}
+
+	owner, ok := reg.routes[anyMethod+" "+pattern]
+	return owner, ok
+}
+
+// Err returns all conflicts found while registering routes
+func (reg *routeRegistry) Err() error {
+	return errors.Join(reg.conflicts...)
+}
+
+// router returns a recorder registering the routes on behalf of the module
+func (reg *routeRegistry) router(router chi.Router, module string, prefix string) chi.Router {
+	return &routeRecorder{
+		Router:   router,
+		registry: reg,
+		module:   module,
+		prefix:   prefix,
+	}
+}
+
+// add records the route and reports whether it can be registered, the scope
+// is the mount point of the router registering the route
+func (reg *routeRegistry) add(method string, pattern string, module string, scope string) bool {
+	normalized := normalizeRoutePattern(pattern)
+
+	// the route would be shadowed by the mounted handler or replace it
+	scope = normalizeRoutePattern(scope)
+	for mount, owner := range reg.mounts {
+		if underMount(normalized, mount) && !underMount(scope, mount) {
+			reg.conflicts = append(reg.conflicts, fmt.Errorf(
+				"route %s %s of %s is under the mount point %s of %s", method, pattern, module, mount, owner))
+			return false
+		}
+	}
+
+	// any method conflicts with every method of the same pattern
+	keys := []string{method + " " + normalized, anyMethod + " " + normalized}
+	if method == anyMethod {
+		keys = []string{anyMethod + " " + normalized}
+		for key, owner := range reg.routes {
+			if strings.HasSuffix(key, " "+normalized) {
+				reg.conflict(method, pattern, owner, module)
+				return false
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if owner, ok := reg.routes[key]; ok {
+			reg.conflict(method, pattern, owner, module)
+			return false
+		}
+	}
+
+	reg.routes[method+" "+normalized] = module
+	reg.entries = append(reg.entries, RouteEntry{
+		Method:  method,
+		Pattern: pattern,
+		Module:  module,
+	})
+	return true
+}
+
+// addMount records the mount point and reports whether it can be mounted, the
+// routes of the mounted router are recorded afterwards
+func (reg *routeRegistry) addMount(pattern string, module string) bool {
+	normalized := normalizeRoutePattern(pattern)
+	if owner, ok := reg.mounts[normalized]; ok {
+		reg.conflicts = append(reg.conflicts, fmt.Errorf(
+			"mount point %s of %s is already used by %s", pattern, module, owner))
+		return false
+	}
+
+	// chi replaces the routes of the mount point with the mounted handler
+	for _, entry := range reg.entries {
+		if underMount(normalizeRoutePattern(entry.Pattern), normalized) {
+			reg.conflicts = append(reg.conflicts, fmt.Errorf(
+				"mount point %s of %s conflicts with the route %s %s of %s",
+				pattern, module, entry.Method, entry.Pattern, entry.Module))
+			return false
+		}
+	}
+
+	reg.mounts[normalized] = module
+	return true
+}
+
+func (reg *routeRegistry) conflict(method string, pattern string, owner string, module string) {
+	reg.conflicts = append(reg.conflicts, fmt.Errorf(
+		"route %s %s of %s is already registered by %s", method, pattern, module, owner))
+}
+
+// forModule returns a recorder registering on behalf of the module
+func (r *routeRecorder) forModule(module string) *routeRecorder {
+	recorder := *r
+	recorder.module = module
+	return &recorder
+}
+
+func (r *routeRecorder) child(router chi.Router, prefix string) chi.Router {
+	return &routeRecorder{
+		Router:   router,
+		registry: r.registry,
+		module:   r.module,
+		prefix:   prefix,
+	}
+}
+
+func (r *routeRecorder) With(middlewares ...func(http.Handler) http.Handler) chi.Router {
+	return r.child(r.Router.With(middlewares...), r.prefix)
+}
+
+func (r *routeRecorder) Group(fn func(r chi.Router)) chi.Router {
+	return r.child(r.Router.Group(func(router chi.Router) {
+		if fn != nil {
+			fn(r.child(router, r.prefix))
+		}
+	}), r.prefix)
+}
+
+func (r *routeRecorder) Route(pattern string, fn func(r chi.Router)) chi.Router {
+	// the routes of a conflicting sub router are neither recorded nor mounted
+	sub := chi.NewRouter()
+	if !r.registry.addMount(r.prefix+pattern, r.module) {
+		return sub
+	}
+
+	if fn != nil {
+		fn(r.child(sub, r.prefix+pattern))
+	}
+	r.Router.Mount(pattern, sub)
+	return r.child(sub, r.prefix+pattern)
+}
+
+func (r *routeRecorder) Mount(pattern string, h http.Handler) {
+	if !r.registry.addMount(r.prefix+pattern, r.module) {
+		return
+	}
+
+	// the routes of the handler are unknown, record the whole mount point
+	r.registry.add(anyMethod, r.prefix+pattern+"/*", r.module, r.prefix+pattern)
+	r.Router.Mount(pattern, h)
+}
+
+func (r *routeRecorder) Handle(pattern string, h http.Handler) {
+	if r.registry.add(anyMethod, r.prefix+pattern, r.module, r.prefix) {
+		r.Router.Handle(pattern, h)
+	}
+}
+
+func (r *routeRecorder) HandleFunc(pattern string, h http.HandlerFunc) {
+	r.Handle(pattern, h)
+}
+
+func (r *routeRecorder) Method(method string, pattern string, h http.Handler) {
+	if r.registry.add(strings.ToUpper(method), r.prefix+pattern, r.module, r.prefix) {
+		r.Router.Method(method, pattern, h)
+	}
+}
+
+func (r *routeRecorder) MethodFunc(method string, pattern string, h http.HandlerFunc) {
+	r.Method(method, pattern, h)
+}
+
+func (r *routeRecorder) Connect(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodConnect, pattern, h)
+}
+
+func (r *routeRecorder) Delete(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodDelete, pattern, h)
+}
+
+func (r *routeRecorder) Get(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodGet, pattern, h)
+}
+
+func (r *routeRecorder) Head(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodHead, pattern, h)
+}
+
+func (r *routeRecorder) Options(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodOptions, pattern, h)
+}
+
+func (r *routeRecorder) Patch(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodPatch, pattern, h)
+}
+
+func (r *routeRecorder) Post(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodPost, pattern, h)
+}
+
+func (r *routeRecorder) Put(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodPut, pattern, h)
+}
+
+func (r *routeRecorder) Trace(pattern string, h http.HandlerFunc) {
+	r.Method(http.MethodTrace, pattern, h)
+}
+
+// routeModule registers the routes of the module under the prefix using its middlewares
+func routeModule(recorder *routeRecorder, module Module, prefix string, route func(router chi.Router)) {
+	register := func(router chi.Router) {
+		router.Group(func(g chi.Router) {
+			if service, ok := module.(MiddlewareService); ok {
+				for _, middleware := range service.Middlewares() {
+					g.Use(middleware)
+				}
+			}
+			route(g)
+		})
+	}
+
+	r := recorder.forModule(moduleName(module))
+	if prefix != "" {
+		r.Route(prefix, register)
+		return
+	}
+	register(r)
+}
+
+// modulePrefix returns the api mount prefix of the module, if any
+func modulePrefix(module Module) string {
+	if service, ok := module.(PrefixedService); ok {
+		return service.RoutePrefix()
+	}
+	return ""
+}
+
+// underMount checks whether the pattern is served by the mount point
+func underMount(pattern string, mount string) bool {
+	return pattern == mount || strings.HasPrefix(pattern, strings.TrimSuffix(mount, "/")+"/")
+}
+
+// normalizeRoutePattern strips the param names as chi treats them the same
+func normalizeRoutePattern(pattern string) string {
+	return routeParamRegex.ReplaceAllString(pattern, "{}")
+}
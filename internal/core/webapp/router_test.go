@@ -0,0 +1,87 @@
+package webapp
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRouteRecorderConflicts(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name     string
+		register func(r *routeRecorder)
+		conflict bool
+	}{
+		{"mount over route", func(r *routeRecorder) {
+			r.forModule("a").Get("/api/users", handler)
+			r.forModule("b").Mount("/api/users", http.HandlerFunc(handler))
+		}, true},
+		{"mount over nested route", func(r *routeRecorder) {
+			r.forModule("a").Get("/api/users/{id}", handler)
+			r.forModule("b").Mount("/api/users", http.HandlerFunc(handler))
+		}, true},
+		{"route under mount", func(r *routeRecorder) {
+			r.forModule("a").Mount("/api/users", http.HandlerFunc(handler))
+			r.forModule("b").Get("/api/users/{id}", handler)
+		}, true},
+		{"sub router over route", func(r *routeRecorder) {
+			r.forModule("a").Get("/api/users", handler)
+			r.forModule("b").Route("/api", func(r chi.Router) {
+				r.Get("/orders", handler)
+			})
+		}, true},
+		{"same sub router", func(r *routeRecorder) {
+			r.forModule("a").Route("/api", func(r chi.Router) {
+				r.Get("/users", handler)
+			})
+			r.forModule("b").Route("/api", func(r chi.Router) {
+				r.Get("/orders", handler)
+			})
+		}, true},
+		{"sub router routes", func(r *routeRecorder) {
+			r.forModule("a").Route("/api", func(r chi.Router) {
+				r.Get("/users", handler)
+				r.Route("/orders", func(r chi.Router) {
+					r.Get("/", handler)
+					r.Get("/{id}", handler)
+				})
+				r.Mount("/files", http.HandlerFunc(handler))
+			})
+		}, false},
+		{"sibling prefix", func(r *routeRecorder) {
+			r.forModule("a").Get("/api/users", handler)
+			r.forModule("b").Mount("/api/user", http.HandlerFunc(handler))
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newRouteRegistry()
+			test.register(registry.router(chi.NewRouter(), appOwner, "").(*routeRecorder))
+
+			if err := registry.Err(); (err != nil) != test.conflict {
+				t.Fatalf("expected conflict %v, got %v", test.conflict, err)
+			}
+		})
+	}
+}
+
+func TestRouteRecorderSkipsConflictingSubRouter(t *testing.T) {
+	registry := newRouteRegistry()
+	root := registry.router(chi.NewRouter(), appOwner, "").(*routeRecorder)
+	root.forModule("a").Get("/api/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	called := false
+	root.forModule("b").Route("/api", func(r chi.Router) {
+		called = true
+	})
+
+	if called {
+		t.Fatal("expected the routes of the conflicting sub router not to be registered")
+	}
+	if _, ok := registry.Owner(http.MethodGet, "/api/users"); !ok || len(registry.Entries()) != 1 {
+		t.Fatalf("unexpected entries %+v", registry.Entries())
+	}
+}
@@ -0,0 +1,103 @@
+package webapp
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const adminRoutesPath = "/routes"
+
+// RouteInfo describes a route served by the app
+type RouteInfo struct {
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	Module      string   `json:"module"`
+	Middlewares []string `json:"middlewares"`
+	Handler     string   `json:"handler"`
+}
+
+// Routes builds the router exactly as the server does and lists its routes
+func (a *App) Routes() ([]RouteInfo, error) {
+	server, err := a.createServer()
+	if err != nil {
+		return nil, err
+	}
+
+	return walkRoutes(server.Handler.(chi.Routes), a.routes)
+}
+
+// routesHandler serves the routes of the router as JSON
+func routesHandler(router chi.Routes, registry *routeRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		routes, err := walkRoutes(router, registry)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		WriteJSON(w, routes)
+	}
+}
+
+// walkRoutes lists the routes of the router sorted by path and method
+func walkRoutes(router chi.Routes, registry *routeRegistry) ([]RouteInfo, error) {
+	var routes []RouteInfo
+	err := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		info := RouteInfo{
+			Method:      method,
+			Path:        route,
+			Middlewares: make([]string, len(middlewares)),
+			Handler:     handlerName(handler),
+		}
+
+		if owner, ok := registry.Owner(method, route); ok {
+			info.Module = owner
+		}
+
+		for i, middleware := range middlewares {
+			info.Middlewares[i] = funcName(middleware)
+		}
+
+		routes = append(routes, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(routes, func(a, b RouteInfo) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return routes, nil
+}
+
+// handlerName uses the function name of handler funcs, otherwise its type
+func handlerName(handler http.Handler) string {
+	if reflect.TypeOf(handler).Kind() == reflect.Func {
+		return funcName(handler)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", handler), "*")
+}
+
+// funcName returns the name of the function without its package path,
+// e.g. hello.(*Service).hello
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
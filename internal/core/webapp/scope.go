@@ -0,0 +1,146 @@
+package webapp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// ScopeAnnotation is the cobra annotation holding the comma separated scopes of a command
+	ScopeAnnotation = "webapp.scopes"
+
+	ScopeServer    = "server"
+	ScopeMigration = "migration"
+	ScopeWorker    = "worker"
+	ScopeDatabase  = "database"
+	// ScopeAll initializes the module for every command
+	ScopeAll = "*"
+)
+
+// commandReporter is implemented by modules that know whether they only
+// provide commands, e.g. modules created using NewModule
+type commandReporter interface {
+	commandOnly() bool
+}
+
+// WithCommandScopes declares the scopes of the command, the modules of these
+// scopes are initialized before it runs
+func WithCommandScopes(cmd *cobra.Command, scopes ...string) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[ScopeAnnotation] = strings.Join(scopes, ",")
+	return cmd
+}
+
+// CommandScopes returns the scopes of the command or its closest parent
+// declaring them, defaults to the name of its top level command
+func CommandScopes(cmd *cobra.Command) []string {
+	for c := cmd; c != nil; c = c.Parent() {
+		if annotation, ok := c.Annotations[ScopeAnnotation]; ok {
+			return strings.Split(annotation, ",")
+		}
+	}
+
+	if top := topLevelCommand(cmd); top != nil {
+		return []string{top.Name()}
+	}
+	return nil
+}
+
+// initializeModules initializes only the modules needed by the command
+func (a *App) initializeModules(cmd *cobra.Command) error {
+	var (
+		scopes = CommandScopes(cmd)
+		owner  = a.commandOwners[topLevelCommand(cmd)]
+	)
+
+	// the server applies the pending migrations once the databases are opened,
+	// before the modules using them are initialized
+	migrate := a.settings.Migration.AutoMigrate && slices.Contains(scopes, ScopeServer)
+
+	log.Trace("initializing modules...", log.WithField("scopes", scopes))
+	for _, module := range a.modules {
+		if module != owner && !moduleInScopes(module, scopes) {
+			continue
+		}
+
+		if err := module.Init(cmd.Context()); err != nil {
+			a.closeModules()
+			return fmt.Errorf("failed to initialize module %s: %w", moduleName(module), err)
+		}
+		a.initialized = append(a.initialized, module)
+
+		if migrate && isDBOpened() {
+			migrate = false
+			if err := a.autoMigrate(cmd.Context()); err != nil {
+				a.closeModules()
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// closeModules closes the initialized modules in reverse order
+func (a *App) closeModules() {
+	log.Trace("closing modules...")
+	for i := len(a.initialized) - 1; i >= 0; i-- {
+		module := a.initialized[i]
+		if err := module.Close(); err != nil {
+			log.Error("failed to close module",
+				log.WithField("module", moduleName(module)),
+				log.WithError(err),
+			)
+		}
+	}
+	a.initialized = nil
+}
+
+// moduleInScopes checks the declared scopes of the module, modules without
+// scopes default to the server scope unless they only provide commands
+func moduleInScopes(module Module, scopes []string) bool {
+	var declared []string
+	if scoped, ok := module.(ScopedModule); ok {
+		declared = scoped.Scopes()
+	}
+
+	if len(declared) == 0 && !commandOnly(module) {
+		declared = []string{ScopeServer}
+	}
+
+	for _, scope := range declared {
+		if scope == ScopeAll || slices.Contains(scopes, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandOnly checks whether the module provides commands without routes
+func commandOnly(m Module) bool {
+	if reporter, ok := m.(commandReporter); ok {
+		return reporter.commandOnly()
+	}
+
+	_, cli := m.(CLI)
+	_, api := m.(APIService)
+	_, versioned := m.(APIVersionedService)
+	_, root := m.(RootService)
+	return cli && !api && !versioned && !root
+}
+
+// topLevelCommand returns the direct child of the root command
+func topLevelCommand(cmd *cobra.Command) *cobra.Command {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.HasParent() && !c.Parent().HasParent() {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/cobra"
+)
+
+func TestInitializeModulesAutoMigrate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1_items.up.sql"), []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := Settings{
+		DB: DatabaseSettings{Uri: "sqlite://" + filepath.Join(dir, "app.db")},
+		Migration: MigrationSettings{
+			AutoMigrate: true,
+			Databases:   []string{defaultDbName},
+			Path:        dir,
+		},
+	}
+
+	// the modules are initialized once the migrations are applied
+	migrated := false
+	app := App{settings: &settings}
+	app.modules = []Module{
+		Database(&settings),
+		NewModule(WithName("items"), WithInit(func(ctx context.Context) error {
+			migrated = DB().Migrator().HasTable("items")
+			return nil
+		})),
+	}
+
+	cmd := WithCommandScopes(&cobra.Command{Use: "start"}, ScopeServer, ScopeDatabase)
+	cmd.SetContext(context.Background())
+	if err := app.initializeModules(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer app.closeModules()
+
+	if !migrated {
+		t.Fatal("expected the migrations applied before initializing the modules")
+	}
+}
+
+func TestInitializeModulesAutoMigrateCancelled(t *testing.T) {
+	settings := Settings{
+		DB: DatabaseSettings{Uri: "sqlite://" + filepath.Join(t.TempDir(), "app.db")},
+		Migration: MigrationSettings{
+			AutoMigrate: true,
+			Databases:   []string{defaultDbName},
+			Path:        t.TempDir(),
+		},
+	}
+
+	app := App{settings: &settings, modules: []Module{Database(&settings)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cmd := WithCommandScopes(&cobra.Command{Use: "start"}, ScopeServer, ScopeDatabase)
+	cmd.SetContext(ctx)
+	if err := app.initializeModules(cmd); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled migration to fail the initialization, got %v", err)
+	}
+	if isDBOpened() {
+		t.Fatal("expected the databases closed")
+	}
+}
+
+// cancelingFS cancels the start once the migration file is read
+type cancelingFS struct {
+	fs.FS
+	name   string
+	cancel context.CancelFunc
+}
+
+func (f cancelingFS) Open(name string) (fs.File, error) {
+	if path.Base(name) == f.name {
+		f.cancel()
+	}
+	return f.FS.Open(name)
+}
+
+func TestInitializeModulesAutoMigrateStopped(t *testing.T) {
+	settings := Settings{
+		DB: DatabaseSettings{Uri: "sqlite://" + filepath.Join(t.TempDir(), "app.db")},
+		Migration: MigrationSettings{
+			AutoMigrate: true,
+			Databases:   []string{defaultDbName},
+		},
+	}
+
+	// the start is cancelled while applying the migrations
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func(fsys fs.FS) { MigrationFS = fsys }(MigrationFS)
+	MigrationFS = cancelingFS{
+		FS: fstest.MapFS{
+			"1_create_items.up.sql":  {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);")},
+			"2_create_orders.up.sql": {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY);")},
+		},
+		name:   "2_create_orders.up.sql",
+		cancel: cancel,
+	}
+
+	initialized := false
+	app := App{settings: &settings}
+	app.modules = []Module{
+		Database(&settings),
+		NewModule(WithName("items"), WithInit(func(ctx context.Context) error {
+			initialized = true
+			return nil
+		})),
+	}
+
+	cmd := WithCommandScopes(&cobra.Command{Use: "start"}, ScopeServer, ScopeDatabase)
+	cmd.SetContext(ctx)
+	if err := app.initializeModules(cmd); !errors.Is(err, context.Canceled) {
+		app.closeModules()
+		t.Fatalf("expected the stopped migration to fail the initialization, got %v", err)
+	}
+	if initialized || isDBOpened() {
+		t.Fatal("expected the modules not initialized and the databases closed")
+	}
+}
@@ -14,6 +14,18 @@ type (
 		Server       ServerSettings       `mapstructure:"server"`
 		StaticServer StaticServerSettings `mapstructure:"static_server"`
 		DB           DatabaseSettings     `mapstructure:"db"`
+		// Databases are the named connections opened along the default db
+		Databases   map[string]DatabaseSettings `mapstructure:"databases"`
+		Pagination  PaginationSettings          `mapstructure:"pagination"`
+		Idempotency IdempotencySettings         `mapstructure:"idempotency"`
+		Batch       BatchSettings               `mapstructure:"batch"`
+		API         APISettings                 `mapstructure:"api"`
+		Admin       AdminSettings               `mapstructure:"admin"`
+		Migration   MigrationSettings           `mapstructure:"migration"`
+		// QueryProfiler counts the queries per request, meant for development
+		QueryProfiler QueryProfilerSettings `mapstructure:"query_profiler"`
+		// Strict fails the startup on module verification warnings, e.g. in CI
+		Strict bool `mapstructure:"strict"`
 
 		extra *viper.Viper
 	}
@@ -35,12 +47,106 @@ type (
 	}
 
 	DatabaseSettings struct {
-		// TODO: add support for multiple databases
-		// TODO: support database other than sql (postgres)
+		// Driver is one of postgres, mysql or sqlite, taken from the uri scheme when empty
+		Driver          string        `mapstructure:"driver"`
 		Uri             string        `mapstructure:"uri"`
 		ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+		ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
 		MaxIdleConns    int           `mapstructure:"max_idle_conns"`
 		MaxOpenConns    int           `mapstructure:"max_open_conns"`
+
+		// ConnectRetries retries the connect at startup with exponential backoff,
+		// e.g. while the database is still booting
+		ConnectRetries    int           `mapstructure:"connect_retries"`
+		ConnectBackoff    time.Duration `mapstructure:"connect_backoff"`
+		ConnectMaxBackoff time.Duration `mapstructure:"connect_max_backoff"`
+		// LazyConnect defers the connect to the first query, sqlite still opens
+		// its file on startup
+		LazyConnect bool `mapstructure:"lazy_connect"`
+
+		// Replicas are the uris of the read replicas using the same driver
+		Replicas []string `mapstructure:"replicas"`
+		// ReplicaPolicy is one of random, round_robin or least_lag
+		ReplicaPolicy string `mapstructure:"replica_policy"`
+		// MaxReplicaLag excludes the lagging replicas from the reads, unlimited when zero
+		MaxReplicaLag        time.Duration `mapstructure:"max_replica_lag"`
+		ReplicaCheckInterval time.Duration `mapstructure:"replica_check_interval"`
+
+		Gorm GormSettings `mapstructure:"gorm"`
+
+		// queryProfiler registers the query profiler plugin on the connection
+		queryProfiler bool
+	}
+
+	GormSettings struct {
+		// LogLevel is one of silent, error, warn or info, every query is logged with info
+		LogLevel      string        `mapstructure:"log_level"`
+		SlowThreshold time.Duration `mapstructure:"slow_threshold"`
+		// LogParams logs the query parameters, they are redacted by default
+		LogParams         bool `mapstructure:"log_params"`
+		LogRecordNotFound bool `mapstructure:"log_record_not_found"`
+
+		PrepareStmt            bool `mapstructure:"prepare_stmt"`
+		SkipDefaultTransaction bool `mapstructure:"skip_default_transaction"`
+		// TranslateError converts the driver errors, e.g. to gorm.ErrDuplicatedKey
+		TranslateError bool   `mapstructure:"translate_error"`
+		TablePrefix    string `mapstructure:"table_prefix"`
+		SingularTable  bool   `mapstructure:"singular_table"`
+	}
+
+	PaginationSettings struct {
+		DefaultLimit int `mapstructure:"default_limit"`
+		MaxLimit     int `mapstructure:"max_limit"`
+		// CursorSecret is used to sign the cursors, generated randomly when empty
+		CursorSecret string `mapstructure:"cursor_secret"`
+	}
+
+	IdempotencySettings struct {
+		// Enabled applies the Idempotency middleware to the api routes
+		Enabled bool          `mapstructure:"enabled"`
+		TTL     time.Duration `mapstructure:"ttl"`
+		// LockTimeout lets a retry take over a key still in progress, e.g. left
+		// by a crashed process, never when zero
+		LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	}
+
+	BatchSettings struct {
+		// Enabled registers the /api/batch endpoint
+		Enabled     bool `mapstructure:"enabled"`
+		MaxRequests int  `mapstructure:"max_requests"`
+		// Concurrency limits the parallel sub requests, unlimited when zero
+		Concurrency int `mapstructure:"concurrency"`
+	}
+
+	APISettings struct {
+		// VersionHeader selects the api version of unversioned requests
+		VersionHeader string `mapstructure:"version_header"`
+	}
+
+	AdminSettings struct {
+		// Enabled registers the admin endpoints, e.g. <prefix>/routes,
+		// they are not authenticated so only enable them on internal networks
+		Enabled bool   `mapstructure:"enabled"`
+		Prefix  string `mapstructure:"prefix"`
+	}
+
+	MigrationSettings struct {
+		// AutoMigrate applies the pending migrations of the databases on start,
+		// one app instance migrates while the others wait for it
+		AutoMigrate bool     `mapstructure:"auto_migrate"`
+		Databases   []string `mapstructure:"databases"`
+		// Path reads the migrations from disk instead of the embedded ones
+		Path string `mapstructure:"path"`
+	}
+
+	QueryProfilerSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// MaxQueries warns on requests issuing more queries, unlimited when zero
+		MaxQueries int `mapstructure:"max_queries"`
+		// RepeatThreshold warns on a statement repeated with different parameters
+		RepeatThreshold int `mapstructure:"repeat_threshold"`
+		// Headers adds the Server-Timing and X-Query-Count response headers
+		Headers bool `mapstructure:"headers"`
 	}
 )
 
@@ -48,6 +154,23 @@ func (s *Settings) GetExtra() *viper.Viper {
 	return s.extra
 }
 
+// DatabaseConnections returns the settings of every named database, the db
+// settings are used for the default one unless it is named explicitly. The
+// named databases inherit the pool and gorm settings of the db
+func (s *Settings) DatabaseConnections() map[string]DatabaseSettings {
+	connections := make(map[string]DatabaseSettings, len(s.Databases)+1)
+	connections[defaultDbName] = s.DB
+	for name, settings := range s.Databases {
+		connections[name] = settings
+	}
+
+	for name, settings := range connections {
+		settings.queryProfiler = s.QueryProfiler.Enabled
+		connections[name] = settings
+	}
+	return connections
+}
+
 func loadSettings(name string, shortName string) Settings {
 	// default settings
 	settings := Settings{
@@ -69,8 +192,56 @@ func loadSettings(name string, shortName string) Settings {
 			ConnMaxLifetime: 60 * time.Second,
 			MaxIdleConns:    10,
 			MaxOpenConns:    10,
+
+			ConnectRetries:    5,
+			ConnectBackoff:    500 * time.Millisecond,
+			ConnectMaxBackoff: 10 * time.Second,
+
+			ReplicaPolicy:        "random",
+			ReplicaCheckInterval: 10 * time.Second,
+
+			Gorm: GormSettings{
+				LogLevel:       "warn",
+				SlowThreshold:  200 * time.Millisecond,
+				TranslateError: true,
+			},
+		},
+		Databases: map[string]DatabaseSettings{},
+		Pagination: PaginationSettings{
+			DefaultLimit: 20,
+			MaxLimit:     100,
+			CursorSecret: "",
 		},
-		extra: nil,
+		Idempotency: IdempotencySettings{
+			Enabled:     false,
+			TTL:         24 * time.Hour,
+			LockTimeout: time.Minute,
+		},
+		Batch: BatchSettings{
+			Enabled:     false,
+			MaxRequests: 20,
+			Concurrency: 5,
+		},
+		API: APISettings{
+			VersionHeader: "API-Version",
+		},
+		Admin: AdminSettings{
+			Enabled: false,
+			Prefix:  "/admin",
+		},
+		Migration: MigrationSettings{
+			AutoMigrate: false,
+			Databases:   []string{defaultDbName},
+			Path:        "",
+		},
+		QueryProfiler: QueryProfilerSettings{
+			Enabled:         false,
+			MaxQueries:      50,
+			RepeatThreshold: 5,
+			Headers:         true,
+		},
+		Strict: false,
+		extra:  nil,
 	}
 
 	// use viper for configuration
@@ -92,8 +263,23 @@ func loadSettings(name string, shortName string) Settings {
 
 	// load settings
 	v.Unmarshal(&settings)
+	inheritDatabaseSettings(v, &settings)
 
 	// set the setting's config
 	settings.extra = v.Sub("extra")
 	return settings
 }
+
+// inheritDatabaseSettings decodes every named database over the db settings,
+// only the connection itself is not inherited
+func inheritDatabaseSettings(v *viper.Viper, settings *Settings) {
+	for name := range settings.Databases {
+		database := settings.DB
+		database.Driver = ""
+		database.Uri = ""
+		database.Replicas = nil
+
+		v.UnmarshalKey("databases."+name, &database)
+		settings.Databases[name] = database
+	}
+}
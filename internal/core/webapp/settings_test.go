@@ -0,0 +1,49 @@
+package webapp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestInheritDatabaseSettings(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(`
+db:
+  uri: postgres://localhost/app
+  replicas: [postgres://replica/app]
+  max_open_conns: 30
+  gorm:
+    log_level: error
+databases:
+  analytics:
+    uri: mysql://localhost/analytics
+    max_idle_conns: 2
+    gorm:
+      prepare_stmt: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := Settings{DB: DatabaseSettings{
+		MaxIdleConns:    10,
+		ConnMaxLifetime: time.Minute,
+		Gorm:            GormSettings{LogLevel: "warn", TranslateError: true},
+	}}
+	v.Unmarshal(&settings)
+	inheritDatabaseSettings(v, &settings)
+
+	analytics := settings.DatabaseConnections()["analytics"]
+	switch {
+	case analytics.Uri != "mysql://localhost/analytics" || len(analytics.Replicas) != 0:
+		t.Fatalf("unexpected connection %s %v", analytics.Uri, analytics.Replicas)
+	case analytics.MaxOpenConns != 30 || analytics.MaxIdleConns != 2 || analytics.ConnMaxLifetime != time.Minute:
+		t.Fatalf("unexpected pool settings %+v", analytics)
+	case analytics.Gorm != GormSettings{LogLevel: "error", TranslateError: true, PrepareStmt: true}:
+		t.Fatalf("unexpected gorm settings %+v", analytics.Gorm)
+	}
+}
@@ -0,0 +1,187 @@
+package webapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	sqlitedriver "modernc.org/sqlite"
+)
+
+const (
+	defaultTxMaxRetries = 3
+	txRetryBaseDelay    = 20 * time.Millisecond
+)
+
+// retryable postgres error codes
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// retryable mysql and sqlite error codes
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+	sqliteBusy           = 5
+	sqliteLocked         = 6
+)
+
+type (
+	// TxOptions configures the transaction created by WithTx
+	TxOptions struct {
+		DB         string
+		Isolation  sql.IsolationLevel
+		ReadOnly   bool
+		MaxRetries int
+	}
+
+	TxOption func(*TxOptions)
+
+	// txContextKey stores the transaction per database name
+	txContextKey struct {
+		name string
+	}
+)
+
+// WithTxDB uses the named database connection for the transaction
+func WithTxDB(name string) TxOption {
+	return func(o *TxOptions) {
+		o.DB = name
+	}
+}
+
+// WithTxIsolation sets the isolation level of the transaction
+func WithTxIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *TxOptions) {
+		o.Isolation = level
+	}
+}
+
+// WithTxReadOnly marks the transaction as read only
+func WithTxReadOnly() TxOption {
+	return func(o *TxOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// WithTxRetries sets how many times a transaction is retried on
+// serialization failures and deadlocks, 0 disables retry
+func WithTxRetries(n int) TxOption {
+	return func(o *TxOptions) {
+		o.MaxRetries = n
+	}
+}
+
+// WithTx runs fn inside a transaction bound to the returned context, nested
+// calls join the current transaction using a savepoint. The outermost
+// transaction is retried on serialization failures and deadlocks, so fn
+// must be safe to be called more than once.
+func WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	options := TxOptions{
+		DB:         defaultDbName,
+		MaxRetries: defaultTxMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	run := func(tx *gorm.DB) error {
+		return fn(ContextWithTx(ctx, tx, options.DB))
+	}
+
+	// join the current transaction, gorm creates a savepoint for nested transaction
+	if tx, ok := TxFromContext(ctx, options.DB); ok {
+		return tx.Transaction(run)
+	}
+
+	txOptions := sql.TxOptions{
+		Isolation: options.Isolation,
+		ReadOnly:  options.ReadOnly,
+	}
+
+	db, err := GetDB(options.DB)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := db.WithContext(ctx).Transaction(run, &txOptions)
+		if err == nil || attempt >= options.MaxRetries || !isRetryableTxError(err) {
+			return err
+		}
+
+		log.Debug("retrying transaction",
+			log.WithContext(ctx),
+			log.WithField("attempt", attempt+1),
+			log.WithError(err),
+		)
+
+		// exponential backoff with jitter
+		delay := txRetryBaseDelay << attempt
+		delay += time.Duration(rand.Int64N(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// ContextWithTx stores the transaction for the named database in the context
+func ContextWithTx(ctx context.Context, tx *gorm.DB, names ...string) context.Context {
+	name := defaultDbName
+	if len(names) > 0 {
+		name = names[0]
+	}
+
+	return context.WithValue(ctx, txContextKey{name: name}, tx)
+}
+
+// TxFromContext returns the transaction of the named database in the context
+func TxFromContext(ctx context.Context, names ...string) (*gorm.DB, bool) {
+	name := defaultDbName
+	if len(names) > 0 {
+		name = names[0]
+	}
+
+	tx, ok := ctx.Value(txContextKey{name: name}).(*gorm.DB)
+	return tx, ok
+}
+
+// DBContext returns the transaction in the context if any, otherwise
+// the named database connection bound to the context
+func DBContext(ctx context.Context, names ...string) *gorm.DB {
+	if tx, ok := TxFromContext(ctx, names...); ok {
+		return tx.WithContext(ctx)
+	}
+
+	return DB(names...).WithContext(ctx)
+}
+
+func isRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+
+	// the extended result codes share the primary code in the lower byte
+	var sqliteErr *sqlitedriver.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+type txItem struct {
+	ID   int64
+	Name string
+}
+
+func TestWithTxNestedRollback(t *testing.T) {
+	openTestDB(t, "tx_nested", &txItem{})
+	repo := NewRepository[txItem]("tx_nested")
+	ctx := context.Background()
+
+	err := repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &txItem{Name: "outer"}); err != nil {
+			return err
+		}
+
+		// only the savepoint of the nested transaction is rolled back
+		nested := repo.WithTx(ctx, func(ctx context.Context) error {
+			if err := repo.Create(ctx, &txItem{Name: "inner"}); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		if nested == nil {
+			t.Fatal("expected the nested transaction to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := repo.Find(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "outer" {
+		t.Fatalf("expected only the outer item, got %+v", items)
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	openTestDB(t, "tx_retry", &txItem{})
+	repo := NewRepository[txItem]("tx_retry")
+
+	tests := []struct {
+		name     string
+		err      error
+		retries  int
+		attempts int
+	}{
+		{"serialization failure", &pgconn.PgError{Code: pgSerializationFailure}, 3, 2},
+		{"deadlock", &pgconn.PgError{Code: pgDeadlockDetected}, 3, 2},
+		{"retries exhausted", &pgconn.PgError{Code: pgSerializationFailure}, 0, 1},
+		{"not retryable", errors.New("failed"), 3, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := repo.WithTx(context.Background(), func(ctx context.Context) error {
+				attempts++
+				if err := repo.Create(ctx, &txItem{Name: test.name}); err != nil {
+					return err
+				}
+				if attempts == 1 {
+					return test.err
+				}
+				return nil
+			}, WithTxRetries(test.retries))
+
+			if attempts != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, attempts)
+			}
+			if (err == nil) != (test.attempts > 1) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			// the failed attempts are rolled back
+			count, err := repo.Count(context.Background(), func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ?", test.name)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := int64(test.attempts - 1); count != expected {
+				t.Fatalf("expected %d stored items, got %d", expected, count)
+			}
+		})
+	}
+}
+
+func TestRepositoryJoinsContextTx(t *testing.T) {
+	// a single connection blocks any query outside of the transaction
+	openTestDB(t, "tx_repository", &txItem{})
+	repo := NewRepository[txItem]("tx_repository")
+
+	err := WithTx(context.Background(), func(ctx context.Context) error {
+		if _, ok := TxFromContext(ctx, "tx_repository"); !ok {
+			t.Fatal("expected the transaction in the context")
+		}
+		if err := repo.Create(ctx, &txItem{Name: "pending"}); err != nil {
+			return err
+		}
+
+		exists, err := repo.Exists(ctx)
+		if err != nil || !exists {
+			t.Fatalf("expected the pending item visible in the transaction, got %v %v", exists, err)
+		}
+		return errors.New("rollback")
+	}, WithTxDB("tx_repository"))
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+
+	if exists, err := repo.Exists(context.Background()); err != nil || exists {
+		t.Fatalf("expected the item rolled back, got %v %v", exists, err)
+	}
+}
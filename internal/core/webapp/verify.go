@@ -0,0 +1,183 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+)
+
+var (
+	// capabilityInterfaces are all interfaces recognised on a module
+	capabilityInterfaces = []reflect.Type{
+		reflect.TypeFor[Module](),
+		reflect.TypeFor[NamedModule](),
+		reflect.TypeFor[APIService](),
+		reflect.TypeFor[APIVersionedService](),
+		reflect.TypeFor[RootService](),
+		reflect.TypeFor[PrefixedService](),
+		reflect.TypeFor[MiddlewareService](),
+		reflect.TypeFor[CLI](),
+		reflect.TypeFor[ScopedModule](),
+		reflect.TypeFor[MigrationService](),
+		reflect.TypeFor[MigrationSetService](),
+	}
+
+	// routingInterfaces contribute routes, commands or migrations to the app, their
+	// signatures are distinctive enough to detect misspelling
+	routingInterfaces = []reflect.Type{
+		reflect.TypeFor[APIService](),
+		reflect.TypeFor[APIVersionedService](),
+		reflect.TypeFor[RootService](),
+		reflect.TypeFor[CLI](),
+		reflect.TypeFor[MigrationService](),
+		reflect.TypeFor[MigrationSetService](),
+	}
+)
+
+type (
+	// capabilityReporter is implemented by modules that know whether they
+	// expose any capability, e.g. modules created using NewModule
+	capabilityReporter interface {
+		hasCapability() bool
+	}
+
+	// moduleProblem is found while verifying a module, the suspected ones are
+	// only guessed from the method name and never fail the startup
+	moduleProblem struct {
+		message   string
+		suspected bool
+	}
+)
+
+// verifyModules checks every module for missing or misspelled capabilities,
+// the problems are logged as warnings or returned as error in strict mode
+func (a *App) verifyModules() error {
+	var errs []error
+	for _, module := range a.modules {
+		for _, problem := range verifyModule(module) {
+			if a.settings.Strict && !problem.suspected {
+				errs = append(errs, fmt.Errorf("module %s: %s", moduleName(module), problem.message))
+				continue
+			}
+
+			log.Warning(problem.message, log.WithField("module", moduleName(module)))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// verifyModule returns the problems found on the module
+func verifyModule(m Module) []moduleProblem {
+	var (
+		problems []moduleProblem
+		value    = reflect.ValueOf(m)
+		typ      = value.Type()
+		known    = capabilityMethods(capabilityInterfaces)
+		routing  = capabilityMethods(routingInterfaces)
+	)
+
+	for i := 0; i < typ.NumMethod(); i++ {
+		var (
+			name      = typ.Method(i).Name
+			signature = value.Method(i).Type()
+		)
+
+		if expected, ok := known[name]; ok {
+			if signature != expected {
+				problems = append(problems, moduleProblem{message: fmt.Sprintf(
+					"method %s has signature %s instead of %s", name, signature, expected)})
+			}
+			continue
+		}
+
+		for _, capability := range sortedKeys(known) {
+			expected := known[capability]
+			_, isRouting := routing[capability]
+			sameSignature := isRouting && signature == expected
+			if sameSignature || similarName(name, capability) {
+				problems = append(problems, moduleProblem{
+					message:   fmt.Sprintf("method %s looks like a misspelled %s capability", name, capability),
+					suspected: !sameSignature,
+				})
+				break
+			}
+		}
+	}
+
+	if !hasCapability(m) {
+		problems = append(problems, moduleProblem{message: "module exposes no recognised capability"})
+	}
+
+	return problems
+}
+
+// hasCapability reports whether the module exposes any capability, the
+// modules implementing Module themselves manage their resources through Init
+// and Close, e.g. a background worker, so only the misspelled methods are
+// reported for them
+func hasCapability(m Module) bool {
+	if reporter, ok := m.(capabilityReporter); ok {
+		return reporter.hasCapability()
+	}
+	return true
+}
+
+// capabilityMethods maps the method names of the interfaces to their signature
+func capabilityMethods(interfaces []reflect.Type) map[string]reflect.Type {
+	methods := make(map[string]reflect.Type)
+	for _, iface := range interfaces {
+		for i := 0; i < iface.NumMethod(); i++ {
+			method := iface.Method(i)
+			methods[method.Name] = method.Type
+		}
+	}
+	return methods
+}
+
+// similarName checks whether a is b with different casing or a single typo
+// after the same first letters, e.g. APIRoutes for APIRoute
+func similarName(a string, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+
+	const prefix = 3
+	return len(a) > prefix && len(b) > prefix && a[:prefix] == b[:prefix] && levenshtein(a, b) == 1
+}
+
+func levenshtein(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
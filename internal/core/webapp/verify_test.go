@@ -0,0 +1,76 @@
+package webapp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	verifiedModule struct{}
+
+	// suspectedModule has methods only named like the capabilities
+	suspectedModule struct{ verifiedModule }
+
+	misspelledModule struct{ verifiedModule }
+)
+
+func (verifiedModule) Init(ctx context.Context) error { return nil }
+func (verifiedModule) Close() error                   { return nil }
+func (verifiedModule) APIRoute(router chi.Router)     {}
+
+func (suspectedModule) Closed() bool       { return false }
+func (suspectedModule) Commands() []string { return nil }
+
+func (misspelledModule) ApiRoute(router chi.Router) {}
+
+func TestSimilarName(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		similar bool
+	}{
+		{"APIROUTE", "APIRoute", true},
+		{"APIRoutes", "APIRoute", true},
+		{"Comand", "Command", true},
+		{"Routes", "RootRoute", false},
+		{"Migrate", "Migrations", false},
+		{"Cmd", "Command", false},
+		{"Unit", "Init", false},
+		{"Same", "Name", false},
+		{"MigrationSets", "Migrations", false},
+	}
+	for _, test := range tests {
+		if similar := similarName(test.a, test.b); similar != test.similar {
+			t.Errorf("similarName(%s, %s): expected %v, got %v", test.a, test.b, test.similar, similar)
+		}
+	}
+}
+
+func TestVerifyModuleSuspectedNames(t *testing.T) {
+	problems := verifyModule(suspectedModule{})
+	if len(problems) != 2 || !problems[0].suspected || !problems[1].suspected {
+		t.Fatalf("expected the suspected names to be warned, got %+v", problems)
+	}
+}
+
+func TestVerifyModulesStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		module Module
+		fails  bool
+	}{
+		{"module", verifiedModule{}, false},
+		{"suspected names", suspectedModule{}, false},
+		{"misspelled capability", misspelledModule{}, true},
+		{"no capability", NewModule(WithName("empty")), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := App{settings: &Settings{Strict: true}, modules: []Module{test.module}}
+			if err := app.verifyModules(); (err != nil) != test.fails {
+				t.Fatalf("expected failing %v, got %v", test.fails, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package webapp
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/log"
+	"github.com/go-chi/chi/v5"
+)
+
+var apiVersionRegex = regexp.MustCompile(`^v[0-9]+$`)
+
+type (
+	// APIVersions collects the versioned routes of a module
+	APIVersions struct {
+		routes []apiVersionRoute
+	}
+
+	APIVersionOption func(*apiVersionRoute)
+
+	apiVersionRoute struct {
+		version        string
+		route          func(router chi.Router)
+		deprecation    time.Time
+		sunset         time.Time
+		deprecationURL string
+		module         Module
+		header         string
+	}
+)
+
+// WithDeprecation marks the version as deprecated since the time
+func WithDeprecation(at time.Time) APIVersionOption {
+	return func(r *apiVersionRoute) {
+		r.deprecation = at
+	}
+}
+
+// WithSunset sets the time when the version will be removed
+func WithSunset(at time.Time) APIVersionOption {
+	return func(r *apiVersionRoute) {
+		r.sunset = at
+	}
+}
+
+// WithDeprecationLink links to the documentation of the deprecation
+func WithDeprecationLink(url string) APIVersionOption {
+	return func(r *apiVersionRoute) {
+		r.deprecationURL = url
+	}
+}
+
+// Route registers the routes for the version, e.g. v1 routes are served under /api/v1
+func (v *APIVersions) Route(version string, f func(router chi.Router), opts ...APIVersionOption) {
+	route := apiVersionRoute{
+		version: version,
+		route:   f,
+	}
+
+	for _, opt := range opts {
+		opt(&route)
+	}
+
+	v.routes = append(v.routes, route)
+}
+
+func (r *apiVersionRoute) deprecated() bool {
+	return !r.deprecation.IsZero() || !r.sunset.IsZero()
+}
+
+// headers sets the version, deprecation and sunset headers of the responses
+func (r *apiVersionRoute) headers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(r.header, r.version)
+		if !r.deprecation.IsZero() {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(r.deprecation.Unix(), 10))
+		}
+		if !r.sunset.IsZero() {
+			w.Header().Set("Sunset", r.sunset.UTC().Format(http.TimeFormat))
+		}
+		if r.deprecationURL != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, r.deprecationURL))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// collectAPIVersions gathers the versioned routes of all modules grouped by
+// version, an invalid version fails instead of silently dropping its routes
+func (a *App) collectAPIVersions() (map[string][]apiVersionRoute, error) {
+	versions := make(map[string][]apiVersionRoute)
+	for _, module := range a.modules {
+		service, ok := module.(APIVersionedService)
+		if !ok {
+			continue
+		}
+
+		var collected APIVersions
+		service.APIVersionRoute(&collected)
+		for _, route := range collected.routes {
+			if !apiVersionRegex.MatchString(route.version) {
+				return nil, fmt.Errorf("module %s: invalid api version %q, expected e.g. v1", moduleName(module), route.version)
+			}
+
+			route.module = module
+			versions[route.version] = append(versions[route.version], route)
+		}
+	}
+
+	return versions, nil
+}
+
+// routeAPIVersions mounts every version under its own prefix
+func routeAPIVersions(recorder *routeRecorder, versions map[string][]apiVersionRoute, header string) {
+	for _, version := range sortedAPIVersions(versions) {
+		routes := versions[version]
+		recorder.Route("/"+version, func(r chi.Router) {
+			for _, route := range routes {
+				route.header = header
+				routeModule(r.(*routeRecorder), route.module, modulePrefix(route.module), func(g chi.Router) {
+					g.Use(route.headers)
+					route.route(g)
+				})
+			}
+		})
+
+		modules := make([]string, len(routes))
+		deprecated := false
+		for i, route := range routes {
+			modules[i] = moduleName(route.module)
+			deprecated = deprecated || route.deprecated()
+		}
+
+		log.Info("api version registered",
+			log.WithField("version", version),
+			log.WithField("modules", modules),
+			log.WithField("deprecated", deprecated),
+		)
+	}
+}
+
+// apiVersionNegotiation routes unversioned api requests to the version
+// requested by the version header or the vendor media type in Accept,
+// e.g. application/vnd.<name>.v2+json. The path is only rewritten when served
+// by the version, the routes registered without version are kept as is
+func apiVersionNegotiation(routes chi.Routes, prefix string, name string, header string, versions map[string][]apiVersionRoute) Middleware {
+	mediaType := regexp.MustCompile(`application/vnd\.` + regexp.QuoteMeta(name) + `\.(v[0-9]+)\+json`)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rest, ok := strings.CutPrefix(r.URL.Path, prefix+"/")
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// already versioned by the path
+			first, _, _ := strings.Cut(rest, "/")
+			if _, ok := versions[first]; ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			version := r.Header.Get(header)
+			if matches := mediaType.FindStringSubmatch(r.Header.Get("Accept")); version == "" && matches != nil {
+				version = matches[1]
+			}
+
+			w.Header().Add("Vary", header)
+			w.Header().Add("Vary", "Accept")
+			if version == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				versioned    = prefix + "/" + version + "/" + rest
+				_, supported = versions[version]
+			)
+			switch {
+			case supported && routes.Match(chi.NewRouteContext(), r.Method, versioned):
+				// rewrite the path so the router resolves the versioned route
+				r.URL.Path = versioned
+				r.URL.RawPath = ""
+			case !supported && !routes.Match(chi.NewRouteContext(), r.Method, r.URL.Path):
+				WriteError(w, NewHTTPError(http.StatusBadRequest,
+					fmt.Sprintf("unsupported api version %s", version)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sortedAPIVersions sorts the versions numerically
+func sortedAPIVersions(versions map[string][]apiVersionRoute) []string {
+	sorted := make([]string, 0, len(versions))
+	for version := range versions {
+		sorted = append(sorted, version)
+	}
+
+	slices.SortFunc(sorted, func(a, b string) int {
+		na, _ := strconv.Atoi(strings.TrimPrefix(a, "v"))
+		nb, _ := strconv.Atoi(strings.TrimPrefix(b, "v"))
+		return na - nb
+	})
+	return sorted
+}
+
+// moduleName uses the name of named module, otherwise its type
+func moduleName(m Module) string {
+	if named, ok := m.(NamedModule); ok && named.Name() != "" {
+		return named.Name()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", m), "*")
+}
@@ -0,0 +1,86 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAPIVersionNegotiation(t *testing.T) {
+	versions := map[string][]apiVersionRoute{"v1": nil, "v2": nil}
+	serve := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Use(apiVersionNegotiation(router, "/api", "app", "X-API-Version", versions))
+	router.Route("/api", func(r chi.Router) {
+		r.Get("/hello", serve("hello"))
+		r.Get("/users", serve("users"))
+		r.Get("/v1/users", serve("v1 users"))
+		r.Get("/v2/users", serve("v2 users"))
+		r.Get("/v2/orders", serve("v2 orders"))
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		accept string
+		status int
+		body   string
+	}{
+		{"unversioned", "/api/users", "", "", http.StatusOK, "users"},
+		{"versioned path", "/api/v1/users", "v2", "", http.StatusOK, "v1 users"},
+		{"header", "/api/users", "v2", "", http.StatusOK, "v2 users"},
+		{"media type", "/api/users", "", "application/vnd.app.v1+json", http.StatusOK, "v1 users"},
+		{"header over media type", "/api/users", "v2", "application/vnd.app.v1+json", http.StatusOK, "v2 users"},
+		{"plain route with header", "/api/hello", "v1", "", http.StatusOK, "hello"},
+		{"plain route with media type", "/api/hello", "", "application/vnd.app.v2+json", http.StatusOK, "hello"},
+		{"route of another version", "/api/orders", "v1", "", http.StatusNotFound, ""},
+		{"unsupported version", "/api/orders", "v3", "", http.StatusBadRequest, ""},
+		{"unsupported version of plain route", "/api/hello", "v3", "", http.StatusOK, "hello"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("X-API-Version", test.header)
+			r.Header.Set("Accept", test.accept)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.status || (test.body != "" && w.Body.String() != test.body) {
+				t.Fatalf("expected %d %q, got %d %q", test.status, test.body, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestCollectAPIVersions(t *testing.T) {
+	route := func(r chi.Router) {}
+	tests := []struct {
+		name    string
+		version string
+		valid   bool
+	}{
+		{"valid", "v2", true},
+		{"missing prefix", "2", false},
+		{"minor version", "v1.1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := App{modules: []Module{NewModule(WithAPIVersion(test.version, route))}}
+			versions, err := app.collectAPIVersions()
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got %v", test.valid, err)
+			}
+			if test.valid && len(versions[test.version]) != 1 {
+				t.Fatalf("expected the routes of %s, got %v", test.version, versions)
+			}
+		})
+	}
+}
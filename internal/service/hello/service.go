@@ -23,7 +23,7 @@ func (svc *Service) Close() error {
 	return nil
 }
 
-func (svc *Service) Route(router chi.Router) {
+func (svc *Service) APIRoute(router chi.Router) {
 	router.Get("/hello", svc.hello)
 }
 
@@ -13,7 +13,11 @@ func main() {
 	app := webapp.NewApp("go-fullstack-boilerplate", "WEBAPP")
 	// CLI modules
 	app.Register(cli.Server(app))
-	app.Register(cli.Migration)
+	app.Register(cli.Migration(app))
+	app.Register(cli.Routes(app))
+
+	// Infrastructure modules
+	app.Register(webapp.Database)
 
 	// Service modules
 	app.Register(hello.NewService)
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/euiko/go-fullstack-boilerplate/internal/core/webapp"
+)
+
+//go:embed db/migrations
+var migrations embed.FS
+
+func init() {
+	// inject the migrations into webapp so the binary migrates without the sql files
+	webapp.MigrationFS, _ = fs.Sub(migrations, "db/migrations")
+}